@@ -3,24 +3,56 @@ package cli
 import (
 	"errors"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
 )
 
+// AnnotationConfig names the table and columns holding events to publish
+// as Grafana annotations.
+type AnnotationConfig struct {
+	Table         string `yaml:"table"`
+	TimeColumn    string `yaml:"time"`
+	TimeEndColumn string `yaml:"time-end"`
+	TextColumn    string `yaml:"text"`
+	TagsColumn    string `yaml:"tags"`
+}
+
 // RouteConfig stores SQLite table information to expose to ReST for
-// for simple-json-datasource access.
+// for simple-json-datasource access.  ReadOnly routes, the default, open the
+// DB file without write access, MaxRows limits the rows read by a query (0 for no
+// limit), Location interprets stored text times lacking a time zone (nil
+// for UTC), QueryTimeout cancels queries running longer (0 for no
+// limit), TimeFormat states the encoding of the time column (empty to
+// infer it from the column), and Database also serves the whole DB file
+// under the alias, see DatabaseConfig.
 type RouteConfig struct {
-	DBAlias    string
-	DBFile     string
-	Table      string
-	TimeColumn string
+	DBAlias      string
+	DBFile       string
+	Table        string
+	TimeColumn   string
+	MaxTagValues int
+	Annotations  *AnnotationConfig
+	ReadOnly     bool
+	MaxRows      int
+	Location     *time.Location
+	QueryTimeout time.Duration
+	TimeFormat   string
+	Database     bool
 }
 
-// Config stores application startup options.
+// Config stores application startup options.  Discover lists the DB files
+// to serve a route for every table and time column found at startup, with
+// the Table and TimeColumn of each left empty.
 type Config struct {
-	Routes []RouteConfig
-	Port   int
+	Routes   []RouteConfig
+	Discover []RouteConfig
+	Port     int
 }
 
 type arrayFlags []string
@@ -54,36 +86,420 @@ func Logger() *zap.SugaredLogger {
 func Parse(args []string) (Config, error) {
 	var config Config
 	fs := flag.NewFlagSet("sqlite2grafana", flag.ContinueOnError)
-	var files, filesAlia, tables, columns arrayFlags
+	var files, filesAlia, tables, columns, annotations arrayFlags
 	fs.Var(&files, "db", "Sqlite3 backing file")
 	fs.Var(&filesAlia, "a", "File endpoint alias")
 	fs.Var(&tables, "tab", "Table to serve")
 	fs.Var(&columns, "time", "Time column")
+	fs.Var(&annotations, "ann", "Annotation source, e.g. table=events,time=ts,text=msg, or - for none")
 	fs.IntVar(&config.Port, "port", 4200, "Port serving requests")
+	var maxTagValues int
+	fs.IntVar(&maxTagValues, "max-tag-values", 1000, "Maximum number of values listed for a tag, 0 for no limit")
+	var readOnly bool
+	fs.BoolVar(&readOnly, "read-only", true, "Open DB files without write access")
+	var queryTimeout time.Duration
+	fs.DurationVar(&queryTimeout, "query-timeout", 30*time.Second, "Cancel queries running longer, 0 for no limit")
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "YAML file configuring the port and routes")
+	var discover bool
+	fs.BoolVar(&discover, "discover", false, "Serve every table and time column found in the -db files")
+	var database bool
+	fs.BoolVar(&database, "database", false, "Also serve every table of the -db files under the alias alone")
 	fs.Parse(args)
 
-	if len(files) <= 0 {
-		return config, errors.New("-db <file-name> option required")
+	if len(files) <= 0 && configFile == "" {
+		return config, errors.New("-db <file-name> or -config <file-name> option required")
 	}
 	if len(filesAlia) != 0 && len(filesAlia) != len(files) {
 		return config, errors.New("either all db files must have alias, or none")
 	}
+	if discover {
+		if len(tables) != 0 || len(columns) != 0 || len(annotations) != 0 {
+			return config, errors.New("-discover replaces the -tab, -time, and -ann options")
+		}
+		tables = make(arrayFlags, len(files))
+		columns = make(arrayFlags, len(files))
+	}
 	if len(tables) != len(files) {
 		return config, errors.New("each -db option requires a -tab <table-name> option")
 	}
 	if len(columns) != len(tables) {
 		return config, errors.New("each -tab option requires a -time <time-column> option")
 	}
+	if len(annotations) != 0 && len(annotations) != len(files) {
+		return config, errors.New("either all db files must have annotations, or none")
+	}
+	if maxTagValues < 0 {
+		return config, errors.New("-max-tag-values must not be negative")
+	}
+	if queryTimeout < 0 {
+		return config, errors.New("-query-timeout must not be negative")
+	}
 
 	for i, f := range files {
-		route := RouteConfig{DBFile: f, Table: tables[i], TimeColumn: columns[i]}
+		route := RouteConfig{DBFile: f, Table: tables[i], TimeColumn: columns[i], MaxTagValues: maxTagValues,
+			ReadOnly: readOnly, QueryTimeout: queryTimeout, Database: database}
 		if len(filesAlia) == 0 {
 			route.DBAlias = route.DBFile
 		} else {
 			route.DBAlias = filesAlia[i]
 		}
-		config.Routes = append(config.Routes, route)
+		if len(annotations) > 0 && annotations[i] != "-" {
+			ann, err := parseAnnotationConfig(annotations[i], route.Table)
+			if err != nil {
+				return config, err
+			}
+			route.Annotations = &ann
+		}
+		if discover {
+			config.Discover = append(config.Discover, route)
+		} else {
+			config.Routes = append(config.Routes, route)
+		}
+	}
+
+	if configFile != "" {
+		portSet := false
+		fs.Visit(func(f *flag.Flag) {
+			portSet = portSet || f.Name == "port"
+		})
+		if err := readConfigFile(configFile, &config, portSet, RouteConfig{
+			MaxTagValues: maxTagValues,
+			ReadOnly:     readOnly,
+			QueryTimeout: queryTimeout,
+			Database:     database,
+		}); err != nil {
+			return config, err
+		}
+	}
+	if err := checkUniqueRoutes(config.Routes); err != nil {
+		return config, err
+	}
+	if _, err := config.Databases(); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+// The layout of a configuration file.
+type fileConfig struct {
+	Port         int            `yaml:"port"`
+	MaxTagValues *int           `yaml:"max-tag-values"`
+	QueryTimeout *time.Duration `yaml:"query-timeout"`
+	Database     *bool          `yaml:"database"`
+	Routes       []fileRoute    `yaml:"routes"`
+}
+
+// The layout of a route in a configuration file.  The alias defaults to the
+// DB file name, and the route inherits the max-tag-values of the file when
+// not set, as for query-timeout and database.  Discover routes omit the
+// table and time column.
+type fileRoute struct {
+	Discover     bool              `yaml:"discover"`
+	DBAlias      string            `yaml:"alias"`
+	DBFile       string            `yaml:"db"`
+	Table        string            `yaml:"table"`
+	TimeColumn   string            `yaml:"time"`
+	MaxTagValues *int              `yaml:"max-tag-values"`
+	Annotations  *AnnotationConfig `yaml:"annotations"`
+	ReadOnly     *bool             `yaml:"read-only"`
+	MaxRows      int               `yaml:"max-rows"`
+	Timezone     string            `yaml:"timezone"`
+	QueryTimeout *time.Duration    `yaml:"query-timeout"`
+	TimeFormat   string            `yaml:"time-format"`
+	Database     *bool             `yaml:"database"`
+}
+
+// Read the port and routes from the YAML configuration file, appending the
+// routes to the config.  The port from the file applies unless set from the
+// command line, while the route settings from the command line, the
+// defaults, apply to routes not setting them.
+func readConfigFile(fileName string, config *Config, portSet bool, defaults RouteConfig) error {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %v", err)
+	}
+	var file fileConfig
+	if err := yaml.UnmarshalStrict(contents, &file); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %v", fileName, err)
+	}
+
+	if file.Port < 0 {
+		return fmt.Errorf("config file %s: port %d must not be negative", fileName, file.Port)
+	}
+	if file.Port != 0 && !portSet {
+		config.Port = file.Port
+	}
+	if file.MaxTagValues != nil {
+		if *file.MaxTagValues < 0 {
+			return fmt.Errorf("config file %s: max-tag-values must not be negative", fileName)
+		}
+		defaults.MaxTagValues = *file.MaxTagValues
+	}
+	if file.QueryTimeout != nil {
+		if *file.QueryTimeout < 0 {
+			return fmt.Errorf("config file %s: query-timeout must not be negative", fileName)
+		}
+		defaults.QueryTimeout = *file.QueryTimeout
+	}
+	if file.Database != nil {
+		defaults.Database = *file.Database
+	}
+	if len(file.Routes) == 0 {
+		return fmt.Errorf("config file %s lists no routes", fileName)
+	}
+
+	for i, fr := range file.Routes {
+		route, err := fr.routeConfig(defaults)
+		if err != nil {
+			name := fr.DBAlias
+			if name == "" {
+				name = fr.DBFile
+			}
+			return fmt.Errorf(`config file %s: route %d ("%s/%s/%s"): %v`,
+				fileName, i+1, name, fr.Table, fr.TimeColumn, err)
+		}
+		if fr.Discover {
+			config.Discover = append(config.Discover, route)
+		} else {
+			config.Routes = append(config.Routes, route)
+		}
+	}
+	return nil
+}
+
+// Validate the route from the config file, filling in defaults.
+func (fr fileRoute) routeConfig(defaults RouteConfig) (RouteConfig, error) {
+	route := RouteConfig{
+		DBAlias:      fr.DBAlias,
+		DBFile:       fr.DBFile,
+		Table:        fr.Table,
+		TimeColumn:   fr.TimeColumn,
+		MaxTagValues: defaults.MaxTagValues,
+		ReadOnly:     defaults.ReadOnly,
+		MaxRows:      fr.MaxRows,
+		QueryTimeout: defaults.QueryTimeout,
+		TimeFormat:   fr.TimeFormat,
+		Database:     defaults.Database,
+	}
+	if fr.Discover {
+		if route.DBFile == "" {
+			return route, errors.New("db is required")
+		}
+		if route.Table != "" || route.TimeColumn != "" || fr.Annotations != nil || fr.TimeFormat != "" {
+			return route, errors.New("discover replaces table, time, annotations, and time-format")
+		}
+	} else if route.DBFile == "" || route.Table == "" || route.TimeColumn == "" {
+		return route, errors.New("db, table, and time are required")
+	}
+	if route.DBAlias == "" {
+		route.DBAlias = route.DBFile
+	}
+	if fr.MaxTagValues != nil {
+		if *fr.MaxTagValues < 0 {
+			return route, errors.New("max-tag-values must not be negative")
+		}
+		route.MaxTagValues = *fr.MaxTagValues
+	}
+	if fr.ReadOnly != nil {
+		route.ReadOnly = *fr.ReadOnly
+	}
+	if fr.Database != nil {
+		route.Database = *fr.Database
+	}
+	if route.MaxRows < 0 {
+		return route, errors.New("max-rows must not be negative")
+	}
+	if fr.QueryTimeout != nil {
+		if *fr.QueryTimeout < 0 {
+			return route, errors.New("query-timeout must not be negative")
+		}
+		route.QueryTimeout = *fr.QueryTimeout
+	}
+	if fr.Timezone != "" {
+		loc, err := time.LoadLocation(fr.Timezone)
+		if err != nil {
+			return route, fmt.Errorf(`unknown timezone "%s"`, fr.Timezone)
+		}
+		route.Location = loc
+	}
+	if fr.Annotations != nil {
+		ann := *fr.Annotations
+		if ann.Table == "" {
+			ann.Table = route.Table
+		}
+		if ann.TimeColumn == "" || ann.TextColumn == "" {
+			return route, errors.New("annotations require time and text columns")
+		}
+		route.Annotations = &ann
+	}
+	return route, nil
+}
+
+// TimeFormatConfig states the encoding of a time column.
+type TimeFormatConfig struct {
+	Table      string
+	TimeColumn string
+	Format     string
+}
+
+// DatabaseConfig stores the settings of a DB file served through a single
+// end point, with tables and time columns chosen in queries, for DB aliases
+// with a route setting Database.  The routes naming the alias must agree on
+// ReadOnly, MaxRows, and Location, while MaxTagValues and QueryTimeout are
+// taken from the first of them, and the annotation sources and time formats
+// collected from all of them.
+type DatabaseConfig struct {
+	DBAlias      string
+	DBFile       string
+	MaxTagValues int
+	ReadOnly     bool
+	MaxRows      int
+	Location     *time.Location
+	QueryTimeout time.Duration
+	Annotations  []AnnotationConfig
+	TimeFormats  []TimeFormatConfig
+}
+
+// Databases groups the routes by DB alias, for aliases served as a whole.
+func (config *Config) Databases() ([]DatabaseConfig, error) {
+	served := make(map[string]bool)
+	files := make(map[string]string)
+	for _, route := range config.Routes {
+		if file, ok := files[route.DBAlias]; ok && file != route.DBFile {
+			return nil, fmt.Errorf(`alias "%s" names both %s and %s`, route.DBAlias, file, route.DBFile)
+		}
+		files[route.DBAlias] = route.DBFile
+		served[route.DBAlias] = served[route.DBAlias] || route.Database
+	}
+
+	var result []DatabaseConfig
+	index := make(map[string]int)
+	for _, route := range config.Routes {
+		if !served[route.DBAlias] {
+			continue
+		}
+		i, ok := index[route.DBAlias]
+		if !ok {
+			i = len(result)
+			index[route.DBAlias] = i
+			result = append(result, DatabaseConfig{
+				DBAlias:      route.DBAlias,
+				DBFile:       route.DBFile,
+				MaxTagValues: route.MaxTagValues,
+				ReadOnly:     route.ReadOnly,
+				MaxRows:      route.MaxRows,
+				Location:     route.Location,
+				QueryTimeout: route.QueryTimeout,
+			})
+		} else if err := result[i].checkRoute(route); err != nil {
+			return nil, err
+		}
+		if route.Annotations != nil && !containsAnnotation(result[i].Annotations, *route.Annotations) {
+			result[i].Annotations = append(result[i].Annotations, *route.Annotations)
+		}
+		if route.TimeFormat != "" {
+			result[i].TimeFormats = append(result[i].TimeFormats, TimeFormatConfig{
+				Table:      route.Table,
+				TimeColumn: route.TimeColumn,
+				Format:     route.TimeFormat,
+			})
+		}
+	}
+	return result, nil
+}
+
+// Check that another route naming the DB alias agrees with the settings.
+func (db *DatabaseConfig) checkRoute(route RouteConfig) error {
+	switch {
+	case db.ReadOnly != route.ReadOnly:
+		return fmt.Errorf(`routes of database "%s" disagree on read-only`, route.DBAlias)
+	case db.MaxRows != route.MaxRows:
+		return fmt.Errorf(`routes of database "%s" disagree on max-rows: %d and %d`,
+			route.DBAlias, db.MaxRows, route.MaxRows)
+	case locationName(db.Location) != locationName(route.Location):
+		return fmt.Errorf(`routes of database "%s" disagree on timezone: %s and %s`,
+			route.DBAlias, locationName(db.Location), locationName(route.Location))
+	}
+	return nil
+}
+
+// Name the time zone of stored times, UTC for nil.
+func locationName(loc *time.Location) string {
+	if loc == nil {
+		return time.UTC.String()
+	}
+	return loc.String()
+}
+
+func containsAnnotation(annotations []AnnotationConfig, ann AnnotationConfig) bool {
+	for _, a := range annotations {
+		if a == ann {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that no two routes serve the same end point.
+func checkUniqueRoutes(routes []RouteConfig) error {
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		endPoint := route.EndPoint()
+		if seen[endPoint] {
+			return fmt.Errorf(`duplicate route "%s"`, endPoint)
+		}
+		seen[endPoint] = true
 	}
+	return nil
+}
 
+// Parse an annotation source of comma-separated key=value pairs naming the
+// table, time, timeEnd, text, and tags columns.  The table defaults to
+// the table of the route.
+func parseAnnotationConfig(spec string, table string) (AnnotationConfig, error) {
+	config := AnnotationConfig{Table: table}
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return config, fmt.Errorf(`malformed annotation option "%s" in "%s"`, pair, spec)
+		}
+		switch kv[0] {
+		case "table":
+			config.Table = kv[1]
+		case "time":
+			config.TimeColumn = kv[1]
+		case "timeEnd":
+			config.TimeEndColumn = kv[1]
+		case "text":
+			config.TextColumn = kv[1]
+		case "tags":
+			config.TagsColumn = kv[1]
+		default:
+			return config, fmt.Errorf(`unknown annotation option "%s" in "%s"`, kv[0], spec)
+		}
+	}
+	if config.TimeColumn == "" || config.TextColumn == "" {
+		return config, fmt.Errorf(`annotations require time and text columns in "%s"`, spec)
+	}
 	return config, nil
 }
+
+// EndPoint names the end point serving the route, "alias/table/time".
+func (route RouteConfig) EndPoint() string {
+	return fmt.Sprintf("%s/%s/%s", route.DBAlias, route.Table, route.TimeColumn)
+}
+
+// AddDiscovered appends a route discovered at startup to the routes, unless
+// a route already serves its end point, e.g. one configured with other
+// settings, reporting whether it was added.
+func (config *Config) AddDiscovered(route RouteConfig) bool {
+	for _, r := range config.Routes {
+		if r.EndPoint() == route.EndPoint() {
+			return false
+		}
+	}
+	config.Routes = append(config.Routes, route)
+	return true
+}
@@ -1,13 +1,17 @@
 package cli
 
 import (
+	"io/ioutil"
+	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_ParseArgs(t *testing.T) {
-	expectedRoute := RouteConfig{DBAlias: "db", DBFile: "db.sqlite3", Table: "a", TimeColumn: "ts"}
+	expectedRoute := RouteConfig{DBAlias: "db", DBFile: "db.sqlite3", Table: "a", TimeColumn: "ts", MaxTagValues: 1000,
+		ReadOnly: true, QueryTimeout: 30 * time.Second}
 	args := strings.Split("-port 4000 -tab a -time ts -db db.sqlite3 -a db", " ")
 	config, err := Parse(args)
 
@@ -58,3 +62,290 @@ func Test_RequiresMatchingAlias(t *testing.T) {
 		t.Fatalf(`either all db files must have alias, or none, but got error "%v"`, err)
 	}
 }
+
+func Test_ParseMaxTagValues(t *testing.T) {
+	args := strings.Split("-db db.sqlite3 -tab a -time ts -max-tag-values 10", " ")
+	config, err := Parse(args)
+
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].MaxTagValues != 10 {
+		t.Fatalf(`expected max tag values 10, but got "%+v"`, config.Routes)
+	}
+
+	args = strings.Split("-db db.sqlite3 -tab a -time ts -max-tag-values -1", " ")
+	if _, err := Parse(args); err == nil {
+		t.Fatalf("expected failure on negative max tag values")
+	}
+}
+
+func Test_ParseAnnotations(t *testing.T) {
+	args := strings.Split("-db db.sqlite3 -tab a -time ts -ann time=t,timeEnd=te,text=msg,tags=tags -db db.sqlite3 -tab b -time ts -ann -", " ")
+	config, err := Parse(args)
+
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	expected := AnnotationConfig{Table: "a", TimeColumn: "t", TimeEndColumn: "te", TextColumn: "msg", TagsColumn: "tags"}
+	if len(config.Routes) != 2 || config.Routes[0].Annotations == nil ||
+		*config.Routes[0].Annotations != expected || config.Routes[1].Annotations != nil {
+		t.Fatalf(`expected annotations "%+v" on first route only, but got "%+v"`, expected, config.Routes)
+	}
+}
+
+func Test_RequiresAnnotationColumns(t *testing.T) {
+	for _, spec := range []string{"table=events,time=t", "time=t,text", "time=t,text=msg,color=red"} {
+		args := []string{"-db", "db.sqlite3", "-tab", "a", "-time", "ts", "-ann", spec}
+		if _, err := Parse(args); err == nil {
+			t.Fatalf(`expected error parsing annotations "%s"`, spec)
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "sqlite32grafana-*.yaml")
+	if err != nil {
+		t.Fatalf(`cannot create config file: %v`, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatalf(`cannot write config file: %v`, err)
+	}
+	return f.Name()
+}
+
+func Test_ParseConfigFile(t *testing.T) {
+	fileName := writeConfigFile(t, `
+port: 4300
+max-tag-values: 50
+routes:
+  - db: db.sqlite3
+    alias: db
+    table: a
+    time: ts
+    read-only: true
+    max-rows: 10000
+    timezone: America/Chicago
+    time-format: julian
+    annotations:
+      time: t
+      text: msg
+  - db: other.sqlite3
+    table: b
+    time: dt
+    max-tag-values: 0
+    read-only: false
+`)
+	defer os.Remove(fileName)
+
+	config, err := Parse([]string{"-config", fileName, "-db", "db.sqlite3", "-tab", "c", "-time", "ts"})
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if config.Port != 4300 {
+		t.Fatalf(`expected port 4300 but got %d`, config.Port)
+	}
+	if len(config.Routes) != 3 {
+		t.Fatalf(`expected flag and file routes, but got "%+v"`, config.Routes)
+	}
+	if config.Routes[0].Table != "c" || config.Routes[0].MaxTagValues != 1000 {
+		t.Fatalf(`expected flag route first, but got "%+v"`, config.Routes[0])
+	}
+
+	route := config.Routes[1]
+	if route.DBAlias != "db" || !route.ReadOnly || route.MaxRows != 10000 || route.MaxTagValues != 50 ||
+		route.Location == nil || route.Location.String() != "America/Chicago" || route.TimeFormat != "julian" {
+		t.Fatalf(`unexpected first file route "%+v"`, route)
+	}
+	expected := AnnotationConfig{Table: "a", TimeColumn: "t", TextColumn: "msg"}
+	if route.Annotations == nil || *route.Annotations != expected {
+		t.Fatalf(`expected annotations "%+v", but got "%+v"`, expected, route.Annotations)
+	}
+
+	route = config.Routes[2]
+	if route.DBAlias != "other.sqlite3" || route.ReadOnly || route.MaxTagValues != 0 || route.Location != nil {
+		t.Fatalf(`unexpected second file route "%+v"`, route)
+	}
+}
+
+func Test_PortFlagOverridesConfigFile(t *testing.T) {
+	fileName := writeConfigFile(t, "port: 4300\nroutes:\n  - {db: db.sqlite3, table: a, time: ts}\n")
+	defer os.Remove(fileName)
+
+	config, err := Parse([]string{"-port", "4000", "-config", fileName})
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if config.Port != 4000 {
+		t.Fatalf(`expected port 4000 but got %d`, config.Port)
+	}
+}
+
+func Test_ConfigFileErrorsNameRoute(t *testing.T) {
+	tests := map[string]string{
+		"  - {db: db.sqlite3, table: a}\n":                                                     `route 1 ("db.sqlite3/a/")`,
+		"  - {db: db.sqlite3, table: a, time: ts, max-rows: -1}\n":                             "max-rows",
+		"  - {db: db.sqlite3, alias: x, table: a, time: ts, timezone: Mars/Base}\n":            `route 1 ("x/a/ts"): unknown timezone`,
+		"  - {db: db.sqlite3, table: a, time: ts, color: red}\n":                               "color",
+		"  - {db: db.sqlite3, table: a, time: ts}\n  - {db: db.sqlite3, table: a, time: ts}\n": "duplicate route",
+		"  - {db: db.sqlite3, table: a, time: ts, annotations: {time: t}}\n":                   "annotations require",
+		"  - {db: db.sqlite3, table: a, discover: true}\n":                                     "discover replaces",
+	}
+	for routes, expected := range tests {
+		fileName := writeConfigFile(t, "routes:\n"+routes)
+		_, err := Parse([]string{"-config", fileName})
+		os.Remove(fileName)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf(`expected error containing "%s" for routes "%s", but got "%v"`, expected, routes, err)
+		}
+	}
+}
+
+func Test_ParseDiscover(t *testing.T) {
+	args := strings.Split("-db db.sqlite3 -a db -discover", " ")
+	config, err := Parse(args)
+
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	expected := RouteConfig{DBAlias: "db", DBFile: "db.sqlite3", MaxTagValues: 1000, ReadOnly: true,
+		QueryTimeout: 30 * time.Second}
+	if len(config.Routes) != 0 || len(config.Discover) != 1 || !reflect.DeepEqual(expected, config.Discover[0]) {
+		t.Fatalf(`expected db to discover "%+v", but got "%+v"`, expected, config)
+	}
+
+	fileName := writeConfigFile(t, "routes:\n  - {db: other.sqlite3, discover: true, read-only: false}\n")
+	defer os.Remove(fileName)
+	config, err = Parse([]string{"-config", fileName})
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if len(config.Routes) != 0 || len(config.Discover) != 1 || config.Discover[0].ReadOnly {
+		t.Fatalf(`expected writable db to discover, but got "%+v"`, config)
+	}
+
+	args = strings.Split("-db db.sqlite3 -tab a -time ts -discover", " ")
+	if _, err := Parse(args); err == nil {
+		t.Fatalf("expected failure on table with discovery")
+	}
+}
+
+func Test_AddDiscovered(t *testing.T) {
+	config, err := Parse(strings.Split("-db db.sqlite3 -tab a -time ts -a db -max-tag-values 5", " "))
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	discovered := RouteConfig{DBAlias: "db", DBFile: "db.sqlite3", Table: "a", TimeColumn: "ts"}
+	if config.AddDiscovered(discovered) || len(config.Routes) != 1 || config.Routes[0].MaxTagValues != 5 {
+		t.Fatalf(`expected discovered route to leave the configured route, but got "%+v"`, config.Routes)
+	}
+	discovered.TimeColumn = "dt"
+	if !config.AddDiscovered(discovered) || len(config.Routes) != 2 {
+		t.Fatalf(`expected discovered route added, but got "%+v"`, config.Routes)
+	}
+	if err := checkUniqueRoutes(config.Routes); err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+}
+
+func Test_Databases(t *testing.T) {
+	args := strings.Split("-db db.sqlite3 -tab a -time ts -a db -ann time=t,text=msg "+
+		"-db db.sqlite3 -tab b -time ts -a db -ann time=t,text=msg "+
+		"-db other.sqlite3 -tab a -time ts -a other -ann - -database", " ")
+	config, err := Parse(args)
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	dbs, err := config.Databases()
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if len(dbs) != 2 || dbs[0].DBAlias != "db" || dbs[1].DBFile != "other.sqlite3" {
+		t.Fatalf(`expected databases db and other, but got "%+v"`, dbs)
+	}
+	if len(dbs[0].Annotations) != 2 || dbs[0].Annotations[1].Table != "b" || len(dbs[1].Annotations) != 0 {
+		t.Fatalf(`expected annotations from tables a and b, but got "%+v"`, dbs)
+	}
+
+	config.Routes[2].DBAlias = "db"
+	if _, err := config.Databases(); err == nil {
+		t.Fatalf("expected failure on alias naming two db files")
+	}
+
+	config, err = Parse(strings.Split("-db db.sqlite3 -tab a -time ts -a db", " "))
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if dbs, err := config.Databases(); err != nil || len(dbs) != 0 {
+		t.Fatalf(`expected no databases without opting in, but got "%+v", "%v"`, dbs, err)
+	}
+}
+
+func Test_DatabasesRejectConflicts(t *testing.T) {
+	tests := map[string]string{
+		"  - {db: db.sqlite3, table: a, time: ts, database: true}\n" +
+			"  - {db: db.sqlite3, table: b, time: ts, read-only: false}\n": "read-only",
+		"  - {db: db.sqlite3, table: a, time: ts, database: true, max-rows: 10}\n" +
+			"  - {db: db.sqlite3, table: b, time: ts}\n": "max-rows",
+		"  - {db: db.sqlite3, table: a, time: ts, timezone: America/Chicago}\n" +
+			"  - {db: db.sqlite3, table: b, time: ts, database: true}\n": "timezone",
+	}
+	for routes, expected := range tests {
+		fileName := writeConfigFile(t, "routes:\n"+routes)
+		_, err := Parse([]string{"-config", fileName})
+		os.Remove(fileName)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf(`expected error containing "%s" for routes "%s", but got "%v"`, expected, routes, err)
+		}
+	}
+
+	// routes not served as a whole may differ
+	fileName := writeConfigFile(t, "routes:\n  - {db: db.sqlite3, table: a, time: ts, max-rows: 10}\n"+
+		"  - {db: db.sqlite3, table: b, time: ts}\n")
+	defer os.Remove(fileName)
+	if _, err := Parse([]string{"-config", fileName}); err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+}
+
+func Test_ParseWritable(t *testing.T) {
+	fileName := writeConfigFile(t, "routes:\n  - {db: db.sqlite3, table: b, time: ts}\n")
+	defer os.Remove(fileName)
+
+	args := []string{"-db", "db.sqlite3", "-tab", "a", "-time", "ts", "-read-only=false", "-config", fileName}
+	config, err := Parse(args)
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	if len(config.Routes) != 2 || config.Routes[0].ReadOnly || config.Routes[1].ReadOnly {
+		t.Fatalf(`expected writable routes, but got "%+v"`, config.Routes)
+	}
+}
+
+func Test_ParseQueryTimeout(t *testing.T) {
+	fileName := writeConfigFile(t, `
+query-timeout: 1m
+routes:
+  - {db: db.sqlite3, table: b, time: ts}
+  - {db: db.sqlite3, table: c, time: ts, query-timeout: 5s}
+`)
+	defer os.Remove(fileName)
+
+	args := []string{"-db", "db.sqlite3", "-tab", "a", "-time", "ts", "-query-timeout", "10s", "-config", fileName}
+	config, err := Parse(args)
+	if err != nil {
+		t.Fatalf(`unexpected error "%v"`, err)
+	}
+	expected := []time.Duration{10 * time.Second, time.Minute, 5 * time.Second}
+	for i, route := range config.Routes {
+		if route.QueryTimeout != expected[i] {
+			t.Fatalf(`expected query timeouts %v, but got "%+v"`, expected, config.Routes)
+		}
+	}
+
+	args = strings.Split("-db db.sqlite3 -tab a -time ts -query-timeout -1s", " ")
+	if _, err := Parse(args); err == nil {
+		t.Fatalf("expected failure on negative query timeout")
+	}
+}
@@ -8,4 +8,5 @@ require (
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible
 	github.com/pkg/errors v0.8.1
 	go.uber.org/zap v1.15.0
+	gopkg.in/yaml.v2 v2.4.0
 )
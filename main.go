@@ -17,17 +17,73 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	for _, db := range config.Discover {
+		found, err := sqlite3.Discover(db.DBFile, options(db))
+		if err != nil {
+			log.Fatalf("cannot discover tables in db %s: %+v", db.DBFile, err)
+		}
+		if len(found) == 0 {
+			log.Printf("no time columns found in db %s", db.DBFile)
+		}
+		for _, f := range found {
+			route := db
+			route.Table = f.Table
+			route.TimeColumn = f.TimeColumn
+			if !config.AddDiscovered(route) {
+				log.Printf("skipped discovered route /%s, configured already", route.EndPoint())
+				continue
+			}
+			log.Printf("discovered route /%s", route.EndPoint())
+		}
+	}
+
 	var app = fiber.New()
 	app.Use(logger.New())
 	for _, route := range config.Routes {
-		tsm, err := sqlite3.New(route.DBFile, route.Table, route.TimeColumn)
+		tsm, err := sqlite3.NewWithOptions(route.DBFile, route.Table, route.TimeColumn, options(route))
 		if err != nil {
 			log.Fatalf("cannot open db for route %+v: %+v", route, err)
 		}
 		routes.InstallAllRoutes(app, route, tsm)
 	}
 
+	databases, err := config.Databases()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for _, dbConfig := range databases {
+		timeFormats := make(map[sqlite3.TableTimeColumn]string)
+		for _, tf := range dbConfig.TimeFormats {
+			timeFormats[sqlite3.TableTimeColumn{Table: tf.Table, TimeColumn: tf.TimeColumn}] = tf.Format
+		}
+		db, err := sqlite3.OpenDatabase(dbConfig.DBFile, sqlite3.Options{
+			Writable:    !dbConfig.ReadOnly,
+			MaxRows:     dbConfig.MaxRows,
+			Location:    dbConfig.Location,
+			TimeFormats: timeFormats,
+		})
+		if err != nil {
+			log.Fatalf("cannot open db %s: %+v", dbConfig.DBFile, err)
+		}
+		routes.InstallDatabaseRoutes(app, dbConfig, db)
+	}
+
 	if err := app.Listen(config.Port); err != nil {
 		log.Fatalf("cannot listen on port %d: %+v", config.Port, err)
 	}
 }
+
+// Read the DB options of the route.
+func options(route cli.RouteConfig) sqlite3.Options {
+	opts := sqlite3.Options{
+		Writable: !route.ReadOnly,
+		MaxRows:  route.MaxRows,
+		Location: route.Location,
+	}
+	if route.TimeFormat != "" {
+		opts.TimeFormats = map[sqlite3.TableTimeColumn]string{
+			{Table: route.Table, TimeColumn: route.TimeColumn}: route.TimeFormat,
+		}
+	}
+	return opts
+}
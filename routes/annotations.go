@@ -1,18 +1,95 @@
 package routes
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber"
 	"github.com/jonathanlb/sqlite32grafana/cli"
 	"github.com/jonathanlb/sqlite32grafana/sqlite3"
 )
 
-// InstallAnnotations is a stub to set up the ReST end point for Grafana
-// access metadata around SQLite tables.  It's currently inactive.
+// AnnotationPayload represents a request from Grafana for the events over
+// a time range.
+type AnnotationPayload struct {
+	Range      sqlite3.QueryRange
+	RangeRaw   sqlite3.QueryRangeRaw
+	Annotation json.RawMessage
+}
+
+// Annotation is an event to send back to Grafana in response to an
+// annotation query, echoing the annotation request.
+type Annotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"`
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	IsRegion   bool            `json:"isRegion,omitempty"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// InstallAnnotations sets up the ReST end point for Grafana to read events
+// stored in the annotation table configured for the route.  Routes without
+// an annotation table answer with an empty list.
 func InstallAnnotations(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeriesManager) {
 	endPoint := fmt.Sprintf("%s/%s/%s/annotations", route.DBAlias, route.Table, route.TimeColumn)
-	app.Post(endPoint, func(c *fiber.Ctx) {
-		sugar.Info(c.OriginalURL())
-	})
+	var sources []cli.AnnotationConfig
+	if route.Annotations != nil {
+		sources = append(sources, *route.Annotations)
+	}
+	app.Post(endPoint, annotationsHandler(sources, route.QueryTimeout, tsm.GetAnnotations))
+}
+
+// Answer annotation queries with the events read from all of the sources,
+// giving up after the timeout, if positive.
+func annotationsHandler(sources []cli.AnnotationConfig, timeout time.Duration,
+	getAnnotations func(ctx context.Context, source *sqlite3.AnnotationSource, fromTo *sqlite3.QueryRange, dest *[]sqlite3.Annotation) error) func(c *fiber.Ctx) {
+	return func(c *fiber.Ctx) {
+		var query AnnotationPayload
+		body := []byte(c.Body())
+		err := json.Unmarshal(body, &query)
+		sugar.Debugw("route annotations", "err", err, "body", string(body))
+		if err == nil {
+			err = resolveRange(&query.Range, query.RangeRaw)
+		}
+		if err != nil {
+			send400(c, err)
+			return
+		}
+
+		ctx, cancel := requestContext(c, timeout)
+		defer cancel()
+		result := []Annotation{}
+		for _, ann := range sources {
+			source := sqlite3.AnnotationSource{
+				Table:         ann.Table,
+				TimeColumn:    ann.TimeColumn,
+				TimeEndColumn: ann.TimeEndColumn,
+				TextColumn:    ann.TextColumn,
+				TagsColumn:    ann.TagsColumn,
+			}
+			var events []sqlite3.Annotation
+			if err := getAnnotations(ctx, &source, &query.Range, &events); err != nil {
+				sendQueryError(ctx, c, err)
+				return
+			}
+			for _, event := range events {
+				tags := event.Tags
+				if tags == nil {
+					tags = []string{}
+				}
+				result = append(result, Annotation{
+					Annotation: query.Annotation,
+					Time:       event.Time,
+					TimeEnd:    event.TimeEnd,
+					IsRegion:   event.TimeEnd != 0,
+					Text:       event.Text,
+					Tags:       tags,
+				})
+			}
+		}
+		send200(c, result)
+	}
 }
@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/jonathanlb/sqlite32grafana/cli"
+)
+
+func Test_Annotations(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	db, err := sql.Open("sqlite3", dbFileName)
+	if err != nil {
+		t.Fatalf("cannot open sqlite at %s: %v", dbFileName, err)
+	}
+	queries := []string{
+		"CREATE TABLE events (t DATETIME, msg TEXT, tags TEXT)",
+		"INSERT INTO events (t, msg, tags) VALUES ('2020-04-02', 'deploy', 'deploy,web')",
+		"INSERT INTO events (t, msg, tags) VALUES ('2020-06-02', 'outage', 'incident')",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot execute sqlite query "%s": %v`, q, err)
+		}
+	}
+	db.Close()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{
+		DBAlias: "db", Table: "tab", TimeColumn: "t",
+		Annotations: &cli.AnnotationConfig{Table: "events", TimeColumn: "t", TextColumn: "msg", TagsColumn: "tags"},
+	}
+	InstallAnnotations(app, route, tsm)
+
+	queryStr := `{
+    "range": { "from": "2020-03-16", "to": "2020-05-01" },
+    "annotation": { "name": "deploys", "enable": true, "query": "" }
+  }`
+	resp, err := postResponse(app, "/db/tab/t/annotations", queryStr)
+	check200(t, "annotations", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var annotations []Annotation
+	if err := json.Unmarshal(body, &annotations); err != nil {
+		t.Fatalf("failed to read annotations response: %v", err)
+	}
+	if len(annotations) != 1 ||
+		annotations[0].Text != "deploy" ||
+		annotations[0].Time != 1585785600000 ||
+		len(annotations[0].Tags) != 2 {
+		t.Fatalf("unexpected annotations %+v", annotations)
+	}
+}
+
+func Test_AnnotationsUnconfigured(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "tab", TimeColumn: "t"}
+	InstallAnnotations(app, route, tsm)
+
+	resp, err := postResponse(app, "/db/tab/t/annotations", `{"range": {"from": "2020-03-16", "to": "2020-05-01"}}`)
+	check200(t, "annotations-unconfigured", resp, err)
+	checkBody(t, "annotations-unconfigured", "[]", resp)
+}
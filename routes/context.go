@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber"
+)
+
+// Time between checks that the client is still connected during a request.
+const disconnectPollInterval = 250 * time.Millisecond
+
+// Build the context for the queries of a request, cancelled after the
+// timeout, if positive, or once the client disconnects.  Call the cancel
+// function once the request is answered.
+func requestContext(c *fiber.Ctx, timeout time.Duration) (context.Context, context.CancelFunc) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(c.Context(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(c.Context())
+	}
+	// fasthttp does not signal disconnects, so poll the connection, without
+	// touching the request context, recycled once the request is answered
+	if conn, ok := c.Fasthttp.Conn().(syscall.Conn); ok {
+		url := c.OriginalURL()
+		go func() {
+			ticker := time.NewTicker(disconnectPollInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if peerClosed(conn) {
+						sugar.Debugw("client disconnected", "url", url)
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+	}
+	return ctx, cancel
+}
+
+// Report a failed request, distinguishing requests cancelled by the timeout
+// or by the client disconnecting from bad requests.
+func sendQueryError(ctx context.Context, c *fiber.Ctx, err error) {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		sendError(c, 504, prefixError("query timed out", err))
+	case context.Canceled:
+		// the nginx convention for a client closing the connection
+		sendError(c, 499, prefixError("query cancelled", err))
+	default:
+		send400(c, err)
+	}
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber"
+	"github.com/jonathanlb/sqlite32grafana/cli"
+	"github.com/jonathanlb/sqlite32grafana/sqlite3"
+)
+
+// InstallDatabaseRoutes sets up ReST end points serving all tables of a DB
+// through a single Grafana datasource, with targets naming the table and
+// time column, e.g. "tab.x@t tag".
+func InstallDatabaseRoutes(app *fiber.App, config cli.DatabaseConfig, db *sqlite3.Database) {
+	app.Get(fmt.Sprintf("%s/", config.DBAlias), func(c *fiber.Ctx) {
+		c.Send("ok")
+	})
+
+	app.Post(fmt.Sprintf("%s/search", config.DBAlias), func(c *fiber.Ctx) {
+		var targetJSON searchTarget
+		if len(c.Body()) > 0 {
+			if err := json.Unmarshal([]byte(c.Body()), &targetJSON); err != nil {
+				send400(c, err)
+				return
+			}
+		}
+		target := strings.ToLower(targetJSON.Target)
+
+		ctx, cancel := requestContext(c, config.QueryTimeout)
+		defer cancel()
+		var tables []string
+		if err := db.Tables(ctx, &tables); err != nil {
+			sendQueryError(ctx, c, err)
+			return
+		}
+		var columns []sqlite3.TagKey
+		if err := db.Columns(ctx, &columns); err != nil {
+			sendQueryError(ctx, c, err)
+			return
+		}
+		result := []string{}
+		for _, table := range tables {
+			if strings.Contains(strings.ToLower(table), target) {
+				result = append(result, table)
+			}
+		}
+		for _, col := range columns {
+			if strings.Contains(strings.ToLower(col.Text), target) {
+				result = append(result, col.Text)
+			}
+		}
+		for _, name := range sqlite3.CustomAggregates() {
+			if strings.Contains(name, target) {
+				result = append(result, name+"()")
+			}
+		}
+		send200(c, result)
+	})
+
+	app.Post(fmt.Sprintf("%s/query", config.DBAlias), queryHandler(config.QueryTimeout, func(target sqlite3.QueryTarget, opts sqlite3.TimeSeriesQueryOpts) (
+		sqlite3.TimeSeriesManager, string, *sqlite3.TimeSeriesQueryOpts, error) {
+		resolved, err := db.Resolve(target)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		opts.Filters = db.TableFilters(resolved.Table, opts.Filters)
+		return resolved.Manager, resolved.Target, &opts, nil
+	}))
+
+	app.Post(fmt.Sprintf("%s/annotations", config.DBAlias),
+		annotationsHandler(config.Annotations, config.QueryTimeout, db.GetAnnotations))
+
+	app.Post(fmt.Sprintf("%s/tag-keys", config.DBAlias), func(c *fiber.Ctx) {
+		ctx, cancel := requestContext(c, config.QueryTimeout)
+		defer cancel()
+		var columns []sqlite3.TagKey
+		if err := db.Columns(ctx, &columns); err != nil {
+			sendQueryError(ctx, c, err)
+			return
+		}
+		send200(c, columns)
+	})
+
+	app.Post(fmt.Sprintf("%s/tag-values", config.DBAlias), func(c *fiber.Ctx) {
+		var request tagValuesRequest
+		if err := json.Unmarshal([]byte(c.Body()), &request); err != nil {
+			send400(c, err)
+			return
+		}
+		ctx, cancel := requestContext(c, config.QueryTimeout)
+		defer cancel()
+		var values []string
+		if err := db.GetTagValues(ctx, request.Key, config.MaxTagValues, &values); err != nil {
+			sendQueryError(ctx, c, err)
+			return
+		}
+		result := make([]TagValue, len(values))
+		for i, v := range values {
+			result[i].Text = v
+		}
+		send200(c, result)
+	})
+}
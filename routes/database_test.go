@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/jonathanlb/sqlite32grafana/cli"
+	"github.com/jonathanlb/sqlite32grafana/sqlite3"
+)
+
+func installDatabase(t *testing.T, dbFileName string) *fiber.App {
+	createTimeSeriesManager(dbFileName)
+	db, err := sqlite3.OpenDatabase(dbFileName, sqlite3.Options{})
+	if err != nil {
+		t.Fatalf(`cannot open database: %v`, err)
+	}
+	app := fiber.New(&fiber.Settings{})
+	InstallDatabaseRoutes(app, cli.DatabaseConfig{DBAlias: "db", DBFile: dbFileName}, db)
+	return app
+}
+
+func Test_DatabaseSearch(t *testing.T) {
+	dbFileName := tempFileName(t)
+	defer os.Remove(dbFileName)
+	app := installDatabase(t, dbFileName)
+
+	resp, err := postResponse(app, "/db/search", `{"target": "series.t"}`)
+	check200(t, "database-search", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var searchResults []string
+	if err := json.Unmarshal(body, &searchResults); err != nil {
+		t.Fatalf("failed to read search results response: %v", err)
+	}
+	expected := []string{"series.tag", "series.t"}
+	if !reflect.DeepEqual(expected, searchResults) {
+		t.Fatalf(`expected search result "%+v", but got "%+v"`, expected, searchResults)
+	}
+
+	resp, err = postResponse(app, "/db/search", `{"target": "serie"}`)
+	check200(t, "database-search-tables", resp, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &searchResults); err != nil {
+		t.Fatalf("failed to read search results response: %v", err)
+	}
+	expected = []string{"series", "series.x", "series.tag", "series.t"}
+	if !reflect.DeepEqual(expected, searchResults) {
+		t.Fatalf(`expected table search result "%+v", but got "%+v"`, expected, searchResults)
+	}
+
+	resp, err = postResponse(app, "/db/search", `{"target": "p9"}`)
+	check200(t, "database-search-aggregates", resp, err)
+	body, _ = ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(body, &searchResults); err != nil {
+		t.Fatalf("failed to read search results response: %v", err)
+	}
+	expected = []string{"p90()", "p99()"}
+	if !reflect.DeepEqual(expected, searchResults) {
+		t.Fatalf(`expected aggregate search result "%+v", but got "%+v"`, expected, searchResults)
+	}
+}
+
+func Test_DatabaseQuery(t *testing.T) {
+	dbFileName := tempFileName(t)
+	defer os.Remove(dbFileName)
+	app := installDatabase(t, dbFileName)
+
+	queryStr := `{
+    "range": {
+      "from": "2020-03-16", "to": "2020-05-01"
+    },
+    "targets": [
+      { "target": "series.x@t tag", "refId": "A", "type": "timeserie" },
+      { "target": "x", "refId": "B", "type": "timeserie", "data": {"table": "series"} }
+    ],
+    "adhocFilters": [{"key": "series.tag", "operator": "=", "value": "a"}]
+  }`
+	resp, err := postResponse(app, "/db/query", queryStr)
+	check200(t, "database-query", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var timeseries []Timeseries
+	if err := json.Unmarshal(body, &timeseries); err != nil {
+		t.Fatalf("failed to read timeseries response: %v", err)
+	}
+	if len(timeseries) != 2 || timeseries[0].Target != "a" || len(timeseries[0].DataPoints) != 2 ||
+		timeseries[1].Target != "x" || len(timeseries[1].DataPoints) != 2 {
+		t.Fatalf("expected filtered series a and x, got %+v", timeseries)
+	}
+
+	resp, err = postResponse(app, "/db/query", `{"targets": [{"target": "x"}]}`)
+	checkStatus(t, "database-query-no-table", 400, resp, err)
+}
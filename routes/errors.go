@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jonathanlb/sqlite32grafana/sqlite3"
+	"github.com/pkg/errors"
+)
+
+// requestError is the JSON body of error responses, locating the problem by
+// the refId of the target and the request field at fault, where known.
+type requestError struct {
+	RefID   string `json:"refId,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *requestError) Error() string {
+	var location []string
+	if e.RefID != "" {
+		location = append(location, "target "+e.RefID)
+	}
+	if e.Field != "" {
+		location = append(location, e.Field)
+	}
+	if len(location) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", strings.Join(location, " "), e.Message)
+}
+
+// Describe a problem with a request field.
+func fieldError(field string, format string, args ...interface{}) error {
+	return &requestError{Field: field, Message: fmt.Sprintf(format, args...)}
+}
+
+// Locate an error querying the target, the index-th of the request, by its
+// refId and, for errors parsing the target, its text or data field.
+func targetError(target sqlite3.QueryTarget, index int, err error) error {
+	field := fmt.Sprintf("targets[%d]", index)
+	switch cause := errors.Cause(err).(type) {
+	case *requestError:
+		return err
+	case *sqlite3.TargetError:
+		field += ".target"
+	case *sqlite3.TargetDataError:
+		field += ".data." + cause.Field
+	}
+	return &requestError{RefID: target.RefID, Field: field, Message: err.Error()}
+}
+
+// Prefix the message of the error, keeping the target and field at fault.
+func prefixError(prefix string, err error) error {
+	reqErr := *asRequestError(err)
+	reqErr.Message = prefix + ": " + reqErr.Message
+	return &reqErr
+}
+
+// Convert the error to the body of an error response.
+func asRequestError(err error) *requestError {
+	if reqErr, ok := errors.Cause(err).(*requestError); ok {
+		return reqErr
+	}
+	return &requestError{Message: err.Error()}
+}
@@ -0,0 +1,14 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package routes
+
+import (
+	"syscall"
+)
+
+// Disconnects are not detected on this platform; requests run until done or
+// timed out.
+func peerClosed(conn syscall.Conn) bool {
+	return false
+}
@@ -3,10 +3,12 @@ package routes
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber"
 	"github.com/jonathanlb/sqlite32grafana/cli"
 	"github.com/jonathanlb/sqlite32grafana/sqlite3"
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
 )
 
 // QueryPayload represents a query from Grafana for an exposed table.
@@ -24,19 +26,45 @@ type QueryPayload struct {
 // Timeseries holds a sequence of time-scalar pairs to send back to Grafana
 // in response to a query.
 type Timeseries struct {
-	Target     string      `json:"target"`
-	DataPoints [][]float64 `json:"datapoints"`
+	Target     string          `json:"target"`
+	DataPoints [][]interface{} `json:"datapoints"`
+}
+
+// Table holds rows of values to send back to Grafana in response to a
+// table-type query target.
+type Table struct {
+	Type    string                `json:"type"`
+	Columns []sqlite3.TableColumn `json:"columns"`
+	Rows    [][]interface{}       `json:"rows"`
 }
 
 // InstallQuery establishes a ReST end point exposing a SQLite table for
-// querying.  Currently, only timeseries requests are supported.
+// querying.  Targets of type "table" are answered with rows of values, while
+// all others are answered with timeseries.
 func InstallQuery(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeriesManager) {
 	endPoint := fmt.Sprintf("%s/%s/%s/query", route.DBAlias, route.Table, route.TimeColumn)
-	app.Post(endPoint, func(c *fiber.Ctx) {
+	app.Post(endPoint, queryHandler(route.QueryTimeout, func(target sqlite3.QueryTarget, opts sqlite3.TimeSeriesQueryOpts) (
+		sqlite3.TimeSeriesManager, string, *sqlite3.TimeSeriesQueryOpts, error) {
+		return tsm, target.Target, &opts, nil
+	}))
+}
+
+// A targetResolver finds the manager to query a target with, along with the
+// target text and options to pass it.
+type targetResolver func(target sqlite3.QueryTarget, opts sqlite3.TimeSeriesQueryOpts) (
+	sqlite3.TimeSeriesManager, string, *sqlite3.TimeSeriesQueryOpts, error)
+
+// Answer queries, querying each target with the manager found by resolve,
+// giving up on all targets after the timeout, if positive.
+func queryHandler(timeout time.Duration, resolve targetResolver) func(c *fiber.Ctx) {
+	return func(c *fiber.Ctx) {
 		var query QueryPayload
 		body := []byte(c.Body())
 		err := json.Unmarshal(body, &query)
 		sugar.Debugw("route query", "err", err, "body", string(body), "query", query)
+		if err == nil {
+			err = resolveRange(&query.Range, query.RangeRaw)
+		}
 		if err == nil {
 			err = validateQuery(&query)
 		}
@@ -45,18 +73,41 @@ func InstallQuery(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeriesM
 			return
 		}
 
+		ctx, cancel := requestContext(c, timeout)
+		defer cancel()
 		queryOpts := sqlite3.TimeSeriesQueryOpts{
 			Interval:      query.Interval,
+			IntervalMs:    int64(query.IntervalMs),
 			MaxDataPoints: query.MaxDataPoints,
 			Filters:       query.AdhocFilters,
 		}
 
-		result := []Timeseries{}
-		for _, target := range query.Targets {
-			// TODO switch on target.Type to support table-type queries.
+		result := []interface{}{}
+		for i, target := range query.Targets {
+			targetOpts := queryOpts
+			targetOpts.Data = target.Data
+			tsm, text, opts, err := resolve(target, targetOpts)
+			if err != nil {
+				send400(c, targetError(target, i, err))
+				return
+			}
+			if target.Type == "table" {
+				var table sqlite3.Table
+				if err := tsm.GetTable(ctx, text, &query.Range, opts, &table); err != nil {
+					sendQueryError(ctx, c, targetError(target, i, err))
+					return
+				}
+				result = append(result, Table{
+					Type:    "table",
+					Columns: table.Columns,
+					Rows:    table.Rows,
+				})
+				continue
+			}
+
 			var series map[string][]sqlite3.DataPoint
-			if err := tsm.GetTimeSeries(target.Target, &query.Range, &queryOpts, &series); err != nil {
-				send400(c, err)
+			if err := tsm.GetTimeSeries(ctx, text, &query.Range, opts, &series); err != nil {
+				sendQueryError(ctx, c, targetError(target, i, err))
 				return
 			}
 			for key, data := range series {
@@ -67,20 +118,65 @@ func InstallQuery(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeriesM
 			}
 		}
 		send200(c, result)
-	})
+	}
 }
 
-func datapointsToArray(pts []sqlite3.DataPoint) [][]float64 {
-	arr := make([][]float64, len(pts))
+// Convert data points to the value-time pairs Grafana expects, with null
+// values for null points.
+func datapointsToArray(pts []sqlite3.DataPoint) [][]interface{} {
+	arr := make([][]interface{}, len(pts))
 	for i, p := range pts {
-		arr[i] = make([]float64, 2)
-		arr[i][0] = p.Value
-		arr[i][1] = float64(p.Time)
+		var value interface{} = p.Value
+		if p.Null {
+			value = nil
+		}
+		arr[i] = []interface{}{value, float64(p.Time)}
 	}
 	return arr
 }
 
+// Target types answered by queries, with timeseries for those without.
+var targetTypes = map[string]bool{"": true, "timeserie": true, "timeseries": true, "table": true}
+
+// Check the query for problems to report before querying any target.
 func validateQuery(query *QueryPayload) error {
-	// XXX TODO
+	from, err := validateTime("range.from", query.Range.From)
+	if err != nil {
+		return err
+	}
+	to, err := validateTime("range.to", query.Range.To)
+	if err != nil {
+		return err
+	}
+	if from.After(to) {
+		return fieldError("range", "from %s is later than to %s", query.Range.From, query.Range.To)
+	}
+	if query.MaxDataPoints < 0 {
+		return fieldError("maxDataPoints", "maxDataPoints %d must not be negative", query.MaxDataPoints)
+	}
+	if len(query.Targets) == 0 {
+		return fieldError("targets", "no targets to query")
+	}
+	for i, target := range query.Targets {
+		if !targetTypes[target.Type] {
+			return &requestError{
+				RefID:   target.RefID,
+				Field:   fmt.Sprintf("targets[%d].type", i),
+				Message: fmt.Sprintf(`unknown target type "%s", expected timeserie or table`, target.Type),
+			}
+		}
+	}
 	return nil
 }
+
+// Parse a time of the range, naming the field in errors.
+func validateTime(field string, timeStr string) (time.Time, error) {
+	if timeStr == "" {
+		return time.Time{}, fieldError(field, "missing time")
+	}
+	t, err := timecodex.StringToTime(timeStr)
+	if err != nil {
+		return time.Time{}, fieldError(field, "%v", err)
+	}
+	return t, nil
+}
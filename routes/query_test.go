@@ -4,10 +4,15 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber"
 	"github.com/jonathanlb/sqlite32grafana/cli"
+	"github.com/jonathanlb/sqlite32grafana/sqlite3"
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
 )
 
 func Test_FailEmptyTimeseries(t *testing.T) {
@@ -74,3 +79,208 @@ func Test_GetTimeseries(t *testing.T) {
 		t.Fatalf("read %d series, expected 2", len(timeseries))
 	}
 }
+
+func Test_GetTable(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "tab", TimeColumn: "t"}
+	InstallQuery(app, route, tsm)
+
+	queryStr := `{
+    "range": {
+      "from": "2020-03-16", "to": "2020-05-01"
+    },
+    "targets": [{ "target": "x tag", "refId": "A", "type": "table" }],
+    "maxDataPoints": 1023
+  }`
+	resp, err := postResponse(app, "/db/tab/t/query", queryStr)
+
+	check200(t, "query-table", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var tables []Table
+	if err := json.Unmarshal(body, &tables); err != nil {
+		t.Fatalf("failed to read table response: %v", err)
+	}
+	if len(tables) != 1 || tables[0].Type != "table" {
+		t.Fatalf("expected a single table, got %+v", tables)
+	}
+	expectedColumns := []sqlite3.TableColumn{
+		{Text: "t", Type: "time"},
+		{Text: "x", Type: "number"},
+		{Text: "tag", Type: "string"},
+	}
+	if !reflect.DeepEqual(expectedColumns, tables[0].Columns) {
+		t.Fatalf("expected table columns %+v, got %+v", expectedColumns, tables[0].Columns)
+	}
+	if len(tables[0].Rows) != 4 {
+		t.Fatalf("read %d table rows, expected 4", len(tables[0].Rows))
+	}
+}
+
+func Test_QueryTimeout(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "tab", TimeColumn: "t", QueryTimeout: time.Nanosecond}
+	InstallQuery(app, route, tsm)
+
+	queryStr := `{
+    "range": {
+      "from": "2020-03-16", "to": "2020-05-01"
+    },
+    "targets": [{ "target": "x tag", "refId": "A", "type": "timeserie" }],
+    "maxDataPoints": 1023
+  }`
+	resp, err := postResponse(app, "/db/tab/t/query", queryStr)
+
+	checkStatus(t, "query-timeout", 504, resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var reqErr requestError
+	if err := json.Unmarshal(body, &reqErr); err != nil {
+		t.Fatalf(`failed to read timeout response "%s": %v`, body, err)
+	}
+	if reqErr.RefID != "A" || !strings.HasPrefix(reqErr.Message, "query timed out") {
+		t.Fatalf("expected timeout of target A, got %+v", reqErr)
+	}
+}
+
+func Test_GetTimeseriesRawRange(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+		timecodex.Now = time.Now
+	}()
+	timecodex.Now = func() time.Time { return time.Date(2020, 4, 3, 12, 0, 0, 0, time.UTC) }
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "tab", TimeColumn: "t"}
+	InstallQuery(app, route, tsm)
+
+	queryStr := `{
+    "range": { "raw": { "from": "now-1d/d", "to": "now-1d/d" } },
+    "targets": [{ "target": "x", "refId": "A", "type": "timeserie" }],
+    "maxDataPoints": 1023
+  }`
+	resp, err := postResponse(app, "/db/tab/t/query", queryStr)
+
+	check200(t, "query-raw-range", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var timeseries []Timeseries
+	if err := json.Unmarshal(body, &timeseries); err != nil {
+		t.Fatalf("failed to read timeseries response: %v", err)
+	}
+	if len(timeseries) != 1 || !reflect.DeepEqual([][]interface{}{{200.0, 1585785600000.0}}, timeseries[0].DataPoints) {
+		t.Fatalf("expected yesterday's point, got %+v", timeseries)
+	}
+}
+
+func Test_datapointsToArrayNulls(t *testing.T) {
+	pts := []sqlite3.DataPoint{{Time: 1000, Value: 1.5}, {Time: 2000, Null: true}}
+	bs, err := json.Marshal(datapointsToArray(pts))
+	if err != nil || string(bs) != "[[1.5,1000],[null,2000]]" {
+		t.Fatalf("expected null values in datapoints, got %s, %v", bs, err)
+	}
+}
+
+func Test_GetTimeseriesTargetData(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "tab", TimeColumn: "t"}
+	InstallQuery(app, route, tsm)
+
+	queryStr := `{
+    "range": { "from": "2020-03-16", "to": "2020-05-01" },
+    "targets": [{
+      "refId": "A", "type": "timeserie",
+      "data": { "values": ["x"], "tags": ["tag"], "filters": [{"key": "x", "operator": ">", "value": "100"}] }
+    }],
+    "maxDataPoints": 1023
+  }`
+	resp, err := postResponse(app, "/db/tab/t/query", queryStr)
+
+	check200(t, "query-target-data", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var timeseries []Timeseries
+	if err := json.Unmarshal(body, &timeseries); err != nil {
+		t.Fatalf("failed to read timeseries response: %v", err)
+	}
+	points := 0
+	for _, series := range timeseries {
+		points += len(series.DataPoints)
+	}
+	if len(timeseries) != 2 || points != 3 {
+		t.Fatalf("expected 3 points in 2 series, got %+v", timeseries)
+	}
+
+	queryStr = `{
+    "range": { "from": "2020-03-16", "to": "2020-05-01" },
+    "targets": [{ "refId": "A", "type": "timeserie", "data": { "values": ["x"], "limit": -1 } }]
+  }`
+	resp, err = postResponse(app, "/db/tab/t/query", queryStr)
+	checkStatus(t, "query-bad-target-data", 400, resp, err)
+}
+
+func Test_ValidateQuery(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "tab", TimeColumn: "t"}
+	InstallQuery(app, route, tsm)
+
+	target := `{ "refId": "A", "type": "timeserie", "target": "x" }`
+	cases := []struct {
+		name    string
+		payload string
+		refID   string
+		field   string
+	}{
+		{"missing-from", `{ "range": { "to": "2020-05-01" }, "targets": [` + target + `] }`, "", "range.from"},
+		{"bad-to", `{ "range": { "from": "2020-03-16", "to": "soon" }, "targets": [` + target + `] }`, "", "range.to"},
+		{"reversed-range", `{ "range": { "from": "2020-05-01", "to": "2020-03-16" }, "targets": [` + target + `] }`,
+			"", "range"},
+		{"no-targets", `{ "range": { "from": "2020-03-16", "to": "2020-05-01" }, "targets": [] }`, "", "targets"},
+		{"unknown-type", `{ "range": { "from": "2020-03-16", "to": "2020-05-01" },
+      "targets": [` + target + `, { "refId": "B", "type": "graph", "target": "x" }] }`, "B", "targets[1].type"},
+		{"negative-max", `{ "range": { "from": "2020-03-16", "to": "2020-05-01" }, "targets": [` + target + `],
+      "maxDataPoints": -1 }`, "", "maxDataPoints"},
+		{"bad-target", `{ "range": { "from": "2020-03-16", "to": "2020-05-01" },
+      "targets": [{ "refId": "C", "type": "timeserie", "target": "x nonsense(" }] }`, "C", "targets[0].target"},
+		{"bad-target-data", `{ "range": { "from": "2020-03-16", "to": "2020-05-01" },
+      "targets": [{ "refId": "D", "type": "timeserie", "data": { "values": ["x"], "limit": -1 } }] }`,
+			"D", "targets[0].data.limit"},
+	}
+	for _, c := range cases {
+		resp, err := postResponse(app, "/db/tab/t/query", c.payload)
+		checkStatus(t, c.name, 400, resp, err)
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/json" {
+			t.Fatalf(`%s: expected a JSON error, got content type "%s"`, c.name, contentType)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		var reqErr requestError
+		if err := json.Unmarshal(body, &reqErr); err != nil {
+			t.Fatalf(`%s: failed to read error response "%s": %v`, c.name, body, err)
+		}
+		if reqErr.RefID != c.refID || reqErr.Field != c.field || reqErr.Message == "" {
+			t.Fatalf(`%s: expected refId "%s" and field "%s", got %+v`, c.name, c.refID, c.field, reqErr)
+		}
+	}
+}
@@ -31,7 +31,21 @@ func send200(c *fiber.Ctx, result interface{}) {
 	c.SendStatus(200)
 }
 
+// Answer a bad request with the error as JSON, naming the target and field
+// at fault where known, see requestError.
 func send400(c *fiber.Ctx, err error) {
-	c.SendStatus(400)
-	c.SendString(err.Error())
+	sendError(c, 400, err)
+}
+
+// Answer a failed request with the status and the error as JSON.
+func sendError(c *fiber.Ctx, status int, err error) {
+	body, marshalErr := json.Marshal(asRequestError(err))
+	if marshalErr != nil {
+		c.SendStatus(status)
+		c.SendString(err.Error())
+		return
+	}
+	c.Set("Content-Type", "application/json")
+	c.Send(body)
+	c.SendStatus(status)
 }
@@ -34,8 +34,10 @@ func InstallSearch(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeries
 			target = ""
 		}
 
+		ctx, cancel := requestContext(c, route.QueryTimeout)
+		defer cancel()
 		var tagKeys []sqlite3.TagKey
-		tsm.GetTagKeys(route.Table, &tagKeys)
+		tsm.GetTagKeys(ctx, "", &tagKeys)
 		result := []string{}
 
 		addTagKey := func(tag string) {
@@ -48,6 +50,9 @@ func InstallSearch(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeries
 			addTagKey(strings.ToLower(i.Text))
 		}
 		addTagKey(route.TimeColumn)
+		for _, name := range sqlite3.CustomAggregates() {
+			addTagKey(name + "()")
+		}
 		send200(c, result)
 	})
 }
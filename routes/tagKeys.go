@@ -14,8 +14,10 @@ func InstallTagKeys(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSerie
 	endPoint := fmt.Sprintf("%s/%s/%s/tag-keys", route.DBAlias, route.Table, route.TimeColumn)
 	app.Post(endPoint, func(c *fiber.Ctx) {
 		sugar.Info(c.OriginalURL())
+		ctx, cancel := requestContext(c, route.QueryTimeout)
+		defer cancel()
 		var tagKeys []sqlite3.TagKey
-		tsm.GetTagKeys(route.Table, &tagKeys) // check the error? how could we fix it?
+		tsm.GetTagKeys(ctx, "", &tagKeys) // check the error? how could we fix it?
 		send200(c, tagKeys)
 	})
 }
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/gofiber/fiber"
@@ -8,11 +9,39 @@ import (
 	"github.com/jonathanlb/sqlite32grafana/sqlite3"
 )
 
-// InstallTagValues is an inactive placeholder to publish values in columns
-// labeling timeseries observations.
+type tagValuesRequest struct {
+	Key string `json:"key"`
+}
+
+// TagValue is a value found in a column labeling timeseries observations.
+type TagValue struct {
+	Text string `json:"text"`
+}
+
+// InstallTagValues sets up a ReST end point to publish values in columns
+// labeling timeseries observations, for Grafana to offer in ad hoc filters.
 func InstallTagValues(app *fiber.App, route cli.RouteConfig, tsm sqlite3.TimeSeriesManager) {
 	endPoint := fmt.Sprintf("%s/%s/%s/tag-values", route.DBAlias, route.Table, route.TimeColumn)
 	app.Post(endPoint, func(c *fiber.Ctx) {
-		sugar.Info(c.OriginalURL())
+		var request tagValuesRequest
+		body := []byte(c.Body())
+		if err := json.Unmarshal(body, &request); err != nil {
+			send400(c, err)
+			return
+		}
+		sugar.Debugw("route tag-values", "body", string(body))
+
+		ctx, cancel := requestContext(c, route.QueryTimeout)
+		defer cancel()
+		var values []string
+		if err := tsm.GetTagValues(ctx, route.Table, request.Key, route.MaxTagValues, &values); err != nil {
+			sendQueryError(ctx, c, err)
+			return
+		}
+		result := make([]TagValue, len(values))
+		for i, v := range values {
+			result[i].Text = v
+		}
+		send200(c, result)
 	})
 }
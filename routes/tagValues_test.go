@@ -0,0 +1,63 @@
+package routes
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gofiber/fiber"
+	"github.com/jonathanlb/sqlite32grafana/cli"
+)
+
+func Test_TagValues(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "series", TimeColumn: "t"}
+	InstallTagValues(app, route, tsm)
+
+	resp, err := postResponse(app, "/db/series/t/tag-values", `{"key": "tag"}`)
+	check200(t, "tag-values", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var tagValues []TagValue
+	if err := json.Unmarshal(body, &tagValues); err != nil {
+		t.Fatalf("failed to read tag values response: %v", err)
+	}
+	expected := []TagValue{{Text: "a"}, {Text: "b"}}
+	if !reflect.DeepEqual(expected, tagValues) {
+		t.Fatalf(`expected tag values "%+v", but got "%+v"`, expected, tagValues)
+	}
+}
+
+func Test_TagValuesLimited(t *testing.T) {
+	app := fiber.New(&fiber.Settings{})
+	dbFileName := tempFileName(t)
+	defer func() {
+		os.Remove(dbFileName)
+	}()
+
+	tsm := createTimeSeriesManager(dbFileName)
+	route := cli.RouteConfig{DBAlias: "db", Table: "series", TimeColumn: "t", MaxTagValues: 3}
+	InstallTagValues(app, route, tsm)
+
+	resp, err := postResponse(app, "/db/series/t/tag-values", `{"key": "x"}`)
+	check200(t, "tag-values-limited", resp, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	var tagValues []TagValue
+	if err := json.Unmarshal(body, &tagValues); err != nil {
+		t.Fatalf("failed to read tag values response: %v", err)
+	}
+	expected := []TagValue{{Text: "100"}, {Text: "200"}, {Text: "300"}}
+	if !reflect.DeepEqual(expected, tagValues) {
+		t.Fatalf(`expected tag values "%+v", but got "%+v"`, expected, tagValues)
+	}
+
+	resp, err = postResponse(app, "/db/series/t/tag-values", `{"key": "nope"}`)
+	checkStatus(t, "tag-values-unknown", 400, resp, err)
+}
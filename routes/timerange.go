@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"time"
+
+	"github.com/jonathanlb/sqlite32grafana/sqlite3"
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
+)
+
+// Fill in times missing from the range with the raw range as entered in
+// Grafana, e.g. "now-7d" to "now", found in the range or else in the
+// rangeRaw of the payload.  Relative times round in UTC, the end of the
+// range rounding up as in Grafana.
+func resolveRange(fromTo *sqlite3.QueryRange, rangeRaw sqlite3.QueryRangeRaw) error {
+	raw := fromTo.Raw
+	if raw.From == "" && raw.To == "" {
+		raw = rangeRaw
+	}
+	now := timecodex.Now().UTC()
+	resolve := func(dest *string, rawTime string, roundUp bool) error {
+		if *dest != "" || !timecodex.IsRelativeTime(rawTime) {
+			if *dest == "" {
+				*dest = rawTime
+			}
+			return nil
+		}
+		t, err := timecodex.ParseRelativeTime(rawTime, now, roundUp)
+		if err != nil {
+			return err
+		}
+		*dest = t.Format(time.RFC3339Nano)
+		return nil
+	}
+	if err := resolve(&fromTo.From, raw.From, false); err != nil {
+		return err
+	}
+	return resolve(&fromTo.To, raw.To, true)
+}
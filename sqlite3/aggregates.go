@@ -0,0 +1,96 @@
+package sqlite3
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Aggregate functions the driver adds to SQLite, mapped to the statistic
+// computed from the values aggregated.
+var customAggregates = map[string]func(values []float64) float64{
+	"median":   percentile(50),
+	"p50":      percentile(50),
+	"p90":      percentile(90),
+	"p99":      percentile(99),
+	"stddev":   stddev,
+	"variance": variance,
+}
+
+// CustomAggregates lists the names of the aggregate functions the driver
+// adds to SQLite, in order.
+func CustomAggregates() []string {
+	names := make([]string, 0, len(customAggregates))
+	for name := range customAggregates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// statsAggregator collects the numbers of a group for a statistic,
+// implementing a SQLite aggregate with go-sqlite3 RegisterAggregator.
+type statsAggregator struct {
+	values []float64
+	stat   func(values []float64) float64
+}
+
+// Step collects a value of the group, ignoring NULL and text other than
+// numbers.
+func (agg *statsAggregator) Step(value interface{}) {
+	switch v := value.(type) {
+	case int64:
+		agg.values = append(agg.values, float64(v))
+	case float64:
+		agg.values = append(agg.values, v)
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			agg.values = append(agg.values, f)
+		}
+	}
+}
+
+// Done computes the statistic, NaN, which SQLite takes for NULL, without
+// values.
+func (agg *statsAggregator) Done() float64 {
+	if len(agg.values) == 0 {
+		return math.NaN()
+	}
+	return agg.stat(agg.values)
+}
+
+// Build a statistic finding the percentile of the values, interpolating
+// between the closest values.
+func percentile(p float64) func(values []float64) float64 {
+	return func(values []float64) float64 {
+		sort.Float64s(values)
+		rank := p / 100 * float64(len(values)-1)
+		lower := int(math.Floor(rank))
+		if lower+1 >= len(values) {
+			return values[len(values)-1]
+		}
+		return values[lower] + (rank-float64(lower))*(values[lower+1]-values[lower])
+	}
+}
+
+// Find the sample variance of the values, NaN for a single value.
+func variance(values []float64) float64 {
+	if len(values) < 2 {
+		return math.NaN()
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	sumSquares := 0.0
+	for _, v := range values {
+		sumSquares += (v - mean) * (v - mean)
+	}
+	return sumSquares / float64(len(values)-1)
+}
+
+// Find the sample standard deviation of the values.
+func stddev(values []float64) float64 {
+	return math.Sqrt(variance(values))
+}
@@ -0,0 +1,73 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func Test_customAggregates(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	queries := []string{
+		"CREATE TABLE latency (ts INT, ms REAL)",
+		"INSERT INTO latency VALUES (1, 10), (2, 20), (3, 30), (4, 40), (5, NULL)",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	cases := map[string]float64{
+		"median(ms)":   25,
+		"p50(ms)":      25,
+		"p90(ms)":      37,
+		"p99(ms)":      39.7,
+		"variance(ms)": 500.0 / 3,
+		"stddev(ms)":   math.Sqrt(500.0 / 3),
+	}
+	for expr, expected := range cases {
+		var result float64
+		if err := db.QueryRow("SELECT " + expr + " FROM latency").Scan(&result); err != nil {
+			t.Fatalf(`Unexpected error selecting %s: %+v`, expr, err)
+		}
+		if math.Abs(result-expected) > 1e-9 {
+			t.Fatalf(`Expected %s to be %f, got %f`, expr, expected, result)
+		}
+	}
+
+	var result sql.NullFloat64
+	if err := db.QueryRow("SELECT p99(ms) FROM latency WHERE ts > 4").Scan(&result); err != nil || result.Valid {
+		t.Fatalf(`Expected NULL percentile of no values, got %+v, %v`, result, err)
+	}
+}
+
+func Test_GetTimeSeriesCustomAggregates(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "0", To: "10"}
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "median(x), stddev(x) t(2*(?/2))", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error querying custom aggregates "%+v"`, err)
+	}
+	expected := map[string][]DataPoint{
+		"median(x)": {{Time: 0, Value: 100}, {Time: 2000, Value: 250}, {Time: 4000, Value: 400}},
+		"stddev(x)": {{Time: 0, Null: true}, {Time: 2000, Value: math.Sqrt(5000)}, {Time: 4000, Null: true}},
+	}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Fatalf("Expected custom aggregates %+v, got %+v", expected, ts)
+	}
+}
+
+func Test_customAggregatesInTargets(t *testing.T) {
+	for _, name := range CustomAggregates() {
+		target := name + "(x)"
+		if _, err := parseTargetQuery(target, targetTestSchema, "ts", noTimeBucket); err != nil {
+			t.Fatalf(`Expected aggregate "%s" allowed in targets, got "%v"`, name, err)
+		}
+	}
+}
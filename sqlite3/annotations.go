@@ -0,0 +1,164 @@
+package sqlite3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GetAnnotations reads the events from the source table falling within the
+// time range, or for events with an end time, overlapping the time range.
+func (seriesMan *sqliteTimeSeriesManager) GetAnnotations(ctx context.Context, source *AnnotationSource, fromTo *QueryRange, dest *[]Annotation) error {
+	var schema []TagKey
+	if err := seriesMan.getSchema(source.Table, &schema); err != nil {
+		return errors.Wrap(err, "annotations")
+	}
+	timeColumn := findColumn(schema, source.TimeColumn)
+	textColumn := findColumn(schema, source.TextColumn)
+	if timeColumn == "" || textColumn == "" {
+		return errors.Errorf(`annotation time column "%s" and text column "%s" must exist in table %s`,
+			source.TimeColumn, source.TextColumn, source.Table)
+	}
+	quotedTime := quoteIdent(timeColumn)
+	selected := []string{selectTime(quotedTime, findType(schema, timeColumn)), quoteIdent(textColumn)}
+
+	timeEndColumn := ""
+	if source.TimeEndColumn != "" {
+		if timeEndColumn = findColumn(schema, source.TimeEndColumn); timeEndColumn == "" {
+			return errors.Errorf(`unknown annotation end time column "%s" in table %s`,
+				source.TimeEndColumn, source.Table)
+		}
+		selected = append(selected, selectTime(quoteIdent(timeEndColumn), findType(schema, timeEndColumn)))
+	}
+	tagsColumn := ""
+	if source.TagsColumn != "" {
+		if tagsColumn = findColumn(schema, source.TagsColumn); tagsColumn == "" {
+			return errors.Errorf(`unknown annotation tags column "%s" in table %s`,
+				source.TagsColumn, source.Table)
+		}
+		selected = append(selected, quoteIdent(tagsColumn))
+	}
+
+	fromTime, err := seriesMan.formatUserTimeForQuery(source.Table, timeColumn, fromTo.From, false)
+	if err != nil {
+		return errors.Wrap(err, "get from time for annotations")
+	}
+	toTime, err := seriesMan.formatUserTimeForQuery(source.Table, timeColumn, fromTo.To, true)
+	if err != nil {
+		return errors.Wrap(err, "get to time for annotations")
+	}
+	timeReader, err := seriesMan.getTimeToMillis(source.Table, timeColumn)
+	if err != nil {
+		return errors.Wrap(err, "annotations")
+	}
+
+	var query string
+	var args []interface{}
+	var timeEndReader func(input interface{}) (int64, error)
+	if timeEndColumn == "" {
+		query = fmt.Sprintf("SELECT %s FROM %s WHERE %s >= ? AND %s < ? ORDER BY %s",
+			strings.Join(selected, ", "), quoteIdent(source.Table), quotedTime, quotedTime, quotedTime)
+		args = []interface{}{fromTime, toTime}
+	} else {
+		fromEndTime, err := seriesMan.formatUserTimeForQuery(source.Table, timeEndColumn, fromTo.From, false)
+		if err != nil {
+			return errors.Wrap(err, "get from end time for annotations")
+		}
+		if timeEndReader, err = seriesMan.getTimeToMillis(source.Table, timeEndColumn); err != nil {
+			return errors.Wrap(err, "annotations")
+		}
+		quotedTimeEnd := quoteIdent(timeEndColumn)
+		query = fmt.Sprintf(
+			"SELECT %s FROM %s WHERE %s < ? AND (%s >= ? OR (%s IS NULL AND %s >= ?)) ORDER BY %s",
+			strings.Join(selected, ", "), quoteIdent(source.Table), quotedTime,
+			quotedTimeEnd, quotedTimeEnd, quotedTime, quotedTime)
+		args = []interface{}{toTime, fromEndTime, fromTime}
+	}
+	sugar.Debugw("annotations query", "query", query, "args", args)
+
+	rows, err := seriesMan.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return errors.Wrap(err, "bad query for annotations")
+	}
+	defer rows.Close()
+
+	result := []Annotation{}
+	values := make([]interface{}, len(selected))
+	for i := range values {
+		values[i] = new(interface{})
+	}
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			return errors.Errorf("Cannot scan annotation row: %v", err)
+		}
+		var annotation Annotation
+		if annotation.Time, err = timeReader(scanPointer(values[0])); err != nil {
+			return err
+		}
+		if text := scanPointer(values[1]); text != nil {
+			annotation.Text = toString(text)
+		}
+		next := 2
+		if timeEndColumn != "" {
+			if end := scanPointer(values[next]); end != nil {
+				if annotation.TimeEnd, err = timeEndReader(end); err != nil {
+					return err
+				}
+			}
+			next++
+		}
+		if tagsColumn != "" {
+			if tags := scanPointer(values[next]); tags != nil {
+				annotation.Tags = splitTags(toString(tags))
+			}
+		}
+		result = append(result, annotation)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read annotation rows")
+	}
+	*dest = result
+	sugar.Debugw("annotations completed", "#rows", len(result))
+	return nil
+}
+
+// Convert a value scanned into an *interface{} to a pointer to the
+// value, as expected by the time and value readers, or nil for NULL.
+func scanPointer(scanned interface{}) interface{} {
+	switch v := (*scanned.(*interface{})).(type) {
+	case int64:
+		return &v
+	case float64:
+		return &v
+	case string:
+		return &v
+	case []byte:
+		s := string(v)
+		return &s
+	case time.Time:
+		s := v.Format(time.RFC3339Nano)
+		return &s
+	case bool:
+		i := int64(0)
+		if v {
+			i = 1
+		}
+		return &i
+	default:
+		return nil
+	}
+}
+
+// Split a list of tags separated by commas.
+func splitTags(tags string) []string {
+	result := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
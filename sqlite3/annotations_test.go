@@ -0,0 +1,76 @@
+package sqlite3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func createAnnotationTable(t *testing.T, tsm *sqliteTimeSeriesManager) {
+	queries := []string{
+		"CREATE TABLE events (ts INT, te INT, msg TEXT, tags TEXT)",
+		"INSERT INTO events (ts, te, msg, tags) VALUES (1, NULL, 'deploy', 'deploy, web')",
+		"INSERT INTO events (ts, te, msg, tags) VALUES (3, 6, 'outage', 'incident')",
+		"INSERT INTO events (ts, te, msg, tags) VALUES (8, NULL, 'restart', NULL)",
+	}
+	for _, q := range queries {
+		if _, err := tsm.db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+}
+
+func Test_GetAnnotations(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	createAnnotationTable(t, &tsm)
+
+	source := AnnotationSource{Table: "events", TimeColumn: "ts", TextColumn: "msg", TagsColumn: "tags"}
+	fromTo := QueryRange{From: "0", To: "5"}
+	var events []Annotation
+	if err := tsm.GetAnnotations(context.Background(), &source, &fromTo, &events); err != nil {
+		t.Fatalf(`Unexpected error querying annotations "%+v"`, err)
+	}
+	expected := []Annotation{
+		{Time: 1000, Text: "deploy", Tags: []string{"deploy", "web"}},
+		{Time: 3000, Text: "outage", Tags: []string{"incident"}},
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Fatalf(`Expected annotations "%+v", got "%+v"`, expected, events)
+	}
+}
+
+func Test_GetAnnotationRegions(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	createAnnotationTable(t, &tsm)
+
+	source := AnnotationSource{Table: "events", TimeColumn: "ts", TimeEndColumn: "te", TextColumn: "msg"}
+	fromTo := QueryRange{From: "4", To: "10"}
+	var events []Annotation
+	if err := tsm.GetAnnotations(context.Background(), &source, &fromTo, &events); err != nil {
+		t.Fatalf(`Unexpected error querying annotations "%+v"`, err)
+	}
+	expected := []Annotation{
+		{Time: 3000, TimeEnd: 6000, Text: "outage"},
+		{Time: 8000, Text: "restart"},
+	}
+	if !reflect.DeepEqual(expected, events) {
+		t.Fatalf(`Expected annotations "%+v", got "%+v"`, expected, events)
+	}
+}
+
+func Test_GetAnnotationsFailsOnUnknownColumn(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	createAnnotationTable(t, &tsm)
+
+	source := AnnotationSource{Table: "events", TimeColumn: "ts", TextColumn: "nope"}
+	fromTo := QueryRange{From: "0", To: "10"}
+	var events []Annotation
+	if err := tsm.GetAnnotations(context.Background(), &source, &fromTo, &events); err == nil {
+		t.Fatalf("Expected failure querying annotations with unknown text column")
+	}
+}
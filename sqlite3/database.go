@@ -0,0 +1,256 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Database serves all the tables of a DB file, creating a TimeSeriesManager
+// for each table and time column on first use.
+type Database struct {
+	db *sql.DB
+	// meta reads schemas and tag values without a table of its own.
+	meta     *sqliteTimeSeriesManager
+	mutex    sync.Mutex
+	managers map[TableTimeColumn]*sqliteTimeSeriesManager
+}
+
+// ResolvedTarget is a target queried through a Database, with the table and
+// time column stripped from the target text.
+type ResolvedTarget struct {
+	Manager    TimeSeriesManager
+	Table      string
+	TimeColumn string
+	Target     string
+}
+
+// OpenDatabase opens the DB file to serve its tables.
+func OpenDatabase(dbFileName string, opts Options) (*Database, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	db, err := openDB(dbFileName, opts)
+	if err != nil {
+		return nil, err
+	}
+	meta := &sqliteTimeSeriesManager{db: db, opts: opts}
+	if _, err := meta.getTableNames(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, fmt.Sprintf("cannot read tables of %s", dbFileName))
+	}
+	return &Database{db: db, meta: meta, managers: make(map[TableTimeColumn]*sqliteTimeSeriesManager)}, nil
+}
+
+// Manager returns the manager of the table and time column, or of the first
+// time column found in the table if the time column is empty.
+func (d *Database) Manager(table string, timeColumn string) (TimeSeriesManager, error) {
+	tableName, err := d.tableName(table)
+	if err != nil {
+		return nil, err
+	}
+	var schema []TagKey
+	if err := d.meta.getSchema(tableName, &schema); err != nil {
+		return nil, err
+	}
+	column := ""
+	if timeColumn == "" {
+		for _, col := range schema {
+			if d.meta.isTimeColumn(tableName, col) {
+				column = col.Text
+				break
+			}
+		}
+		if column == "" {
+			return nil, errors.Errorf(`no time column found in table "%s", name one with @column`, table)
+		}
+	} else if column = findColumn(schema, timeColumn); column == "" {
+		return nil, errors.Errorf(`unknown time column "%s" in table "%s"`, timeColumn, table)
+	}
+
+	key := TableTimeColumn{Table: tableName, TimeColumn: column}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	tsm, ok := d.managers[key]
+	if !ok {
+		tsm = &sqliteTimeSeriesManager{db: d.db, table: tableName, timeColumn: column, opts: d.meta.opts}
+		d.managers[key] = tsm
+	}
+	return tsm, nil
+}
+
+// Resolve finds the manager for the table and time column named by the
+// target, e.g. "tab.x@t tag", or failing that, by the target data.  Raw SQL
+// targets name the table and time column only in the target data.
+func (d *Database) Resolve(target QueryTarget) (*ResolvedTarget, error) {
+	var table, timeColumn, text string
+	if _, ok := rawSQL(target.Target); ok {
+		// raw SQL names its tables itself, leaving the target data to
+		// name the table for macros
+		text = target.Target
+	} else {
+		var err error
+		if table, timeColumn, text, err = splitDatabaseTarget(target.Target); err != nil {
+			return nil, err
+		}
+	}
+	if target.Data != nil {
+		if table == "" {
+			table = target.Data.Table
+		}
+		if timeColumn == "" {
+			timeColumn = target.Data.TimeColumn
+		}
+	}
+	if table == "" {
+		return nil, errors.Errorf(`target "%s" names no table, prefix it with the table, e.g. "table.%s"`,
+			target.Target, target.Target)
+	}
+	tsm, err := d.Manager(table, timeColumn)
+	if err != nil {
+		return nil, err
+	}
+	sm := tsm.(*sqliteTimeSeriesManager)
+	return &ResolvedTarget{Manager: tsm, Table: sm.table, TimeColumn: sm.timeColumn, Target: text}, nil
+}
+
+// Tables lists the names of the tables in the DB, in order.
+func (d *Database) Tables(ctx context.Context, dest *[]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	tables, err := d.meta.getTableNames()
+	if err != nil {
+		return err
+	}
+	*dest = tables
+	return nil
+}
+
+// Columns lists the columns of all tables in the DB, with the column names
+// prefixed by the table, as in targets, and their types as for GetTagKeys.
+func (d *Database) Columns(ctx context.Context, dest *[]TagKey) error {
+	tables, err := d.meta.getTableNames()
+	if err != nil {
+		return err
+	}
+	result := []TagKey{}
+	for _, table := range tables {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var schema []TagKey
+		if err := d.meta.getSchema(table, &schema); err != nil {
+			return err
+		}
+		for _, col := range schema {
+			result = append(result, TagKey{Type: sql2grafanaType(col.Type), Text: table + "." + col.Text})
+		}
+	}
+	*dest = result
+	return nil
+}
+
+// GetTagValues lists the values of a column named by the table and column,
+// e.g. "tab.tag".
+func (d *Database) GetTagValues(ctx context.Context, key string, limit int, dest *[]string) error {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return errors.Errorf(`tag key "%s" must name the table, e.g. "table.%s"`, key, key)
+	}
+	table, err := d.tableName(parts[0])
+	if err != nil {
+		return err
+	}
+	return d.meta.GetTagValues(ctx, table, parts[1], limit, dest)
+}
+
+// GetAnnotations reads the events of an annotation source.
+func (d *Database) GetAnnotations(ctx context.Context, source *AnnotationSource, fromTo *QueryRange, dest *[]Annotation) error {
+	return d.meta.GetAnnotations(ctx, source, fromTo, dest)
+}
+
+// TableFilters selects the ad hoc filters applying to the table: those with
+// keys prefixed by the table, e.g. "tab.tag", with the prefix removed, and
+// those without a table prefix.
+func (d *Database) TableFilters(table string, filters []QueryFilter) []QueryFilter {
+	var result []QueryFilter
+	for _, filter := range filters {
+		parts := strings.SplitN(filter.Key, ".", 2)
+		if len(parts) == 1 {
+			result = append(result, filter)
+		} else if strings.EqualFold(parts[0], table) {
+			filter.Key = parts[1]
+			result = append(result, filter)
+		}
+	}
+	return result
+}
+
+// Close releases the DB file.
+func (d *Database) Close() error {
+	return d.db.Close()
+}
+
+// Find the table name as created, ignoring case.
+func (d *Database) tableName(table string) (string, error) {
+	tables, err := d.meta.getTableNames()
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tables {
+		if strings.EqualFold(t, table) {
+			return t, nil
+		}
+	}
+	return "", errors.Errorf(`nonExistentTable: "%s"`, table)
+}
+
+// Split a target queried through a Database into the table prefixing the
+// target, the time column following an "@", and the target with both
+// removed, e.g. "tab.x@t tag" into "tab", "t", and "x tag".  The table and
+// time column are empty if not given.
+func splitDatabaseTarget(target string) (string, string, string, error) {
+	tokens, err := lexTarget(target)
+	if err != nil {
+		return "", "", "", err
+	}
+	isName := func(tok token) bool {
+		return tok.kind == tokIdent || tok.kind == tokQuotedIdent
+	}
+	isPunct := func(tok token, text string) bool {
+		return tok.kind == tokPunct && tok.text == text
+	}
+	fail := func(tok token, msg string) error {
+		return &TargetError{Target: target, Pos: tok.pos, Msg: msg}
+	}
+
+	table, timeColumn := "", ""
+	start := 0
+	if len(tokens) > 2 && isName(tokens[0]) && isPunct(tokens[1], ".") {
+		table = tokens[0].text
+		start = tokens[1].end
+	}
+	var text strings.Builder
+	next := start
+	for i, tok := range tokens {
+		if !isPunct(tok, "@") {
+			continue
+		}
+		if timeColumn != "" {
+			return "", "", "", fail(tok, "only one time column may be named")
+		}
+		if !isName(tokens[i+1]) {
+			return "", "", "", fail(tokens[i+1], `expected a time column following "@"`)
+		}
+		timeColumn = tokens[i+1].text
+		text.WriteString(target[next:tok.pos])
+		next = tokens[i+1].end
+	}
+	text.WriteString(target[next:])
+	return table, timeColumn, strings.TrimSpace(text.String()), nil
+}
@@ -0,0 +1,127 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func createDatabase(t *testing.T) (*Database, string) {
+	dbFileName := tempFileName(t)
+	db, err := sql.Open("sqlite3", dbFileName)
+	if err != nil {
+		t.Fatalf(`Cannot create file-backed db at %s: "%+v"`, dbFileName, err)
+	}
+	queries := []string{
+		"CREATE TABLE temps (ts INT, patient TEXT, tempF REAL)",
+		"INSERT INTO temps VALUES (1588204800, 'a', 98.6), (1588204860, 'b', 99.1), (1588204920, 'a', 98.8)",
+		"CREATE TABLE events (at DATETIME, msg TEXT)",
+		"INSERT INTO events VALUES ('2020-04-01', 'deploy')",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	db.Close()
+
+	database, err := OpenDatabase(dbFileName, Options{})
+	if err != nil {
+		os.Remove(dbFileName)
+		t.Fatalf(`Cannot open database "%+v"`, err)
+	}
+	return database, dbFileName
+}
+
+func Test_splitDatabaseTarget(t *testing.T) {
+	cases := map[string][]string{
+		"tab.x@t tag":           {"tab", "t", "x tag"},
+		`"my tab".avg(x) i(1h)`: {"my tab", "", "avg(x) i(1h)"},
+		"x @ t":                 {"", "t", "x"},
+		"x tag":                 {"", "", "x tag"},
+		"1.5*x":                 {"", "", "1.5*x"},
+	}
+	for target, expected := range cases {
+		table, timeColumn, text, err := splitDatabaseTarget(target)
+		if err != nil || !reflect.DeepEqual([]string{table, timeColumn, text}, expected) {
+			t.Fatalf(`expected "%s" to split into "%v", got "%s", "%s", "%s", %v`,
+				target, expected, table, timeColumn, text, err)
+		}
+	}
+
+	for _, target := range []string{"tab.x@", "tab.x@t@u", "tab.x@1"} {
+		if _, _, _, err := splitDatabaseTarget(target); err == nil {
+			t.Fatalf(`expected error splitting "%s"`, target)
+		}
+	}
+}
+
+func Test_DatabaseResolve(t *testing.T) {
+	database, dbFileName := createDatabase(t)
+	defer os.Remove(dbFileName)
+	defer database.Close()
+
+	resolved, err := database.Resolve(QueryTarget{Target: "TEMPS.tempF patient"})
+	if err != nil {
+		t.Fatalf(`Unexpected error resolving target "%+v"`, err)
+	}
+	if resolved.Table != "temps" || resolved.TimeColumn != "ts" || resolved.Target != "tempF patient" {
+		t.Fatalf(`Unexpected resolved target "%+v"`, resolved)
+	}
+	fromTo := QueryRange{From: "2020-04-29T00:00:00Z", To: "2020-05-01T00:00:00Z"}
+	var series map[string][]DataPoint
+	if err := resolved.Manager.GetTimeSeries(context.Background(), resolved.Target, &fromTo, nil, &series); err != nil {
+		t.Fatalf(`Unexpected error querying resolved target "%+v"`, err)
+	}
+	if len(series["a"]) != 2 || len(series["b"]) != 1 {
+		t.Fatalf(`Unexpected timeseries "%+v"`, series)
+	}
+
+	again, err := database.Resolve(QueryTarget{Target: "msg", Data: &TargetData{Table: "events", TimeColumn: "at"}})
+	if err != nil || again.Table != "events" || again.TimeColumn != "at" {
+		t.Fatalf(`Unexpected resolved target data "%+v", %v`, again, err)
+	}
+	if same, _ := database.Resolve(QueryTarget{Target: "temps.patient@ts"}); same.Manager != resolved.Manager {
+		t.Fatalf("Expected managers to be reused")
+	}
+
+	for _, target := range []string{"tempF", "nope.x", "temps.x@nope", "events.msg@msg@at"} {
+		if _, err := database.Resolve(QueryTarget{Target: target}); err == nil {
+			t.Fatalf(`Expected error resolving "%s"`, target)
+		}
+	}
+}
+
+func Test_DatabaseColumnsAndFilters(t *testing.T) {
+	database, dbFileName := createDatabase(t)
+	defer os.Remove(dbFileName)
+	defer database.Close()
+
+	var tables []string
+	if err := database.Tables(context.Background(), &tables); err != nil ||
+		!reflect.DeepEqual(tables, []string{"events", "temps"}) {
+		t.Fatalf(`Unexpected tables "%+v", %v`, tables, err)
+	}
+
+	var columns []TagKey
+	if err := database.Columns(context.Background(), &columns); err != nil {
+		t.Fatalf(`Unexpected error listing columns "%+v"`, err)
+	}
+	if len(columns) != 5 || columns[0].Text != "events.at" || columns[4] != (TagKey{"number", "temps.tempF"}) {
+		t.Fatalf(`Unexpected columns "%+v"`, columns)
+	}
+
+	var values []string
+	if err := database.GetTagValues(context.Background(), "temps.patient", 0, &values); err != nil ||
+		!reflect.DeepEqual(values, []string{"a", "b"}) {
+		t.Fatalf(`Unexpected tag values "%+v", %v`, values, err)
+	}
+
+	filters := []QueryFilter{{"temps.patient", "=", "a"}, {"events.msg", "=", "x"}, {"tag", "=", "b"}}
+	expected := []QueryFilter{{"patient", "=", "a"}, {"tag", "=", "b"}}
+	if found := database.TableFilters("temps", filters); !reflect.DeepEqual(found, expected) {
+		t.Fatalf(`Expected filters "%+v", but got "%+v"`, expected, found)
+	}
+}
@@ -0,0 +1,82 @@
+package sqlite3
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TableTimeColumn names a table and a column within it holding times.
+type TableTimeColumn struct {
+	Table      string
+	TimeColumn string
+}
+
+// Numeric columns holding times earlier than this are not taken for epoch
+// or Julian day times during discovery.
+var discoverSince = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Discover lists the columns of the tables in the DB file usable as time
+// columns: DATE, DATETIME, and TIMESTAMP columns holding text, and numeric
+// columns holding epoch-looking or Julian day values.
+func Discover(dbFileName string, opts Options) ([]TableTimeColumn, error) {
+	db, err := openDB(dbFileName, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	seriesMan := sqliteTimeSeriesManager{db: db, opts: opts}
+
+	tables, err := seriesMan.getTableNames()
+	if err != nil {
+		return nil, errors.Wrap(err, "discover tables")
+	}
+	var result []TableTimeColumn
+	for _, table := range tables {
+		var schema []TagKey
+		if err := seriesMan.getSchema(table, &schema); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("discover time columns of table %s", table))
+		}
+		for _, col := range schema {
+			if seriesMan.isTimeColumn(table, col) {
+				result = append(result, TableTimeColumn{Table: table, TimeColumn: col.Text})
+			}
+		}
+	}
+	return result, nil
+}
+
+// List the names of the user tables in the DB.
+func (seriesMan *sqliteTimeSeriesManager) getTableNames() ([]string, error) {
+	rows, err := seriesMan.db.Query(
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// Decide whether the column holds times, either declared as DATE, DATETIME,
+// or TIMESTAMP holding text, or holding numbers, epoch seconds, milliseconds,
+// or Julian days, since discoverSince.
+func (seriesMan *sqliteTimeSeriesManager) isTimeColumn(table string, col TagKey) bool {
+	switch typeAffinity(col.Type) {
+	case "INTEGER", "REAL", "NUMERIC":
+		if encoding, err := seriesMan.timeEncoding(table, col.Text); err == nil && encoding.kind == textEncoding {
+			return isDateTimeType(col.Type)
+		}
+		return seriesMan.timesSince(table, col.Text, discoverSince.UnixNano()/1000000)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,44 @@
+package sqlite3
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_Discover(t *testing.T) {
+	dbFileName := tempFileName(t)
+	defer os.Remove(dbFileName)
+	db, err := sql.Open("sqlite3", dbFileName)
+	if err != nil {
+		t.Fatalf(`Cannot create file-backed db at %s: "%+v"`, dbFileName, err)
+	}
+	queries := []string{
+		"CREATE TABLE temps (ts INT, count INT, tempF REAL, note TEXT)",
+		"INSERT INTO temps VALUES (1588204800, 1, 98.6, 'ok'), (1588204860, 2, 99.1, NULL)",
+		"CREATE TABLE events (at DATETIME, msg TEXT, ms INT)",
+		"INSERT INTO events VALUES ('2020-04-01', 'deploy', 1588204800000)",
+		"CREATE TABLE empty (ts INT)",
+		"CREATE TABLE names (name TEXT)",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	db.Close()
+
+	found, err := Discover(dbFileName, Options{})
+	if err != nil {
+		t.Fatalf(`Unexpected discovery error "%+v"`, err)
+	}
+	expected := []TableTimeColumn{
+		{Table: "events", TimeColumn: "at"},
+		{Table: "events", TimeColumn: "ms"},
+		{Table: "temps", TimeColumn: "ts"},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf(`Expected time columns "%+v", but got "%+v"`, expected, found)
+	}
+}
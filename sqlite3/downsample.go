@@ -0,0 +1,237 @@
+package sqlite3
+
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// A downsampler reduces a time-ordered series to at most maxPoints points.
+type downsampler func(pts []DataPoint, maxPoints int) []DataPoint
+
+var downsamplers = map[string]downsampler{
+	"lttb":   lttb,
+	"minmax": minMaxPerBucket,
+	"avg":    avgPerBucket,
+}
+
+// The downsampling algorithm applied when the target does not name one.
+const defaultDownsampler = "lttb"
+
+// Look up the downsampling algorithm by name, with "none" disabling
+// downsampling.
+func getDownsampler(name string) (downsampler, error) {
+	if name == "" {
+		name = defaultDownsampler
+	}
+	if name == "none" {
+		return nil, nil
+	}
+	ds, ok := downsamplers[name]
+	if !ok {
+		return nil, errors.Errorf(`unknown downsampling algorithm "%s"`, name)
+	}
+	return ds, nil
+}
+
+// Reduce the series with Largest-Triangle-Three-Buckets, keeping the first
+// and last points and, from each bucket in between, the point forming the
+// largest triangle with the point kept from the previous bucket and the
+// average of the next bucket.
+// See https://skemman.is/bitstream/1946/15343/3/SS_MSthesis.pdf
+func lttb(pts []DataPoint, maxPoints int) []DataPoint {
+	if maxPoints >= len(pts) || maxPoints <= 0 {
+		return pts
+	}
+	if maxPoints < 3 {
+		return avgPerBucket(pts, maxPoints)
+	}
+
+	result := make([]DataPoint, 0, maxPoints)
+	result = append(result, pts[0])
+	bucketSize := float64(len(pts)-2) / float64(maxPoints-2)
+	a := 0
+	for i := 0; i < maxPoints-2; i++ {
+		// average the next bucket, or use the last point for the final bucket
+		nextStart := int(float64(i+1)*bucketSize) + 1
+		nextEnd := int(float64(i+2)*bucketSize) + 1
+		if nextEnd > len(pts) {
+			nextEnd = len(pts)
+		}
+		var avgTime, avgValue float64
+		for _, p := range pts[nextStart:nextEnd] {
+			avgTime += float64(p.Time)
+			avgValue += p.Value
+		}
+		n := float64(nextEnd - nextStart)
+		avgTime /= n
+		avgValue /= n
+
+		start := int(float64(i)*bucketSize) + 1
+		end := nextStart
+		maxArea := -1.0
+		next := start
+		for j := start; j < end; j++ {
+			area := math.Abs(
+				(float64(pts[a].Time)-avgTime)*(pts[j].Value-pts[a].Value) -
+					(float64(pts[a].Time)-float64(pts[j].Time))*(avgValue-pts[a].Value))
+			if area > maxArea {
+				maxArea = area
+				next = j
+			}
+		}
+		result = append(result, pts[next])
+		a = next
+	}
+	return append(result, pts[len(pts)-1])
+}
+
+// Reduce the series by dividing the time range into equal buckets and
+// keeping the minimum and maximum points from each, in time order.
+func minMaxPerBucket(pts []DataPoint, maxPoints int) []DataPoint {
+	if maxPoints >= len(pts) || maxPoints <= 0 {
+		return pts
+	}
+	buckets := maxPoints / 2
+	if buckets < 1 {
+		return avgPerBucket(pts, maxPoints)
+	}
+
+	result := make([]DataPoint, 0, maxPoints)
+	for _, bucket := range timeBuckets(pts, buckets) {
+		lo, hi := bucket[0], bucket[0]
+		for _, p := range bucket[1:] {
+			if p.Value < lo.Value {
+				lo = p
+			}
+			if p.Value > hi.Value {
+				hi = p
+			}
+		}
+		switch {
+		case lo == hi:
+			result = append(result, lo)
+		case lo.Time < hi.Time || (lo.Time == hi.Time && lo.Value < hi.Value):
+			result = append(result, lo, hi)
+		default:
+			result = append(result, hi, lo)
+		}
+	}
+	return result
+}
+
+// Reduce the series by dividing the time range into equal buckets and
+// replacing each with a point at the average time and value of the bucket.
+func avgPerBucket(pts []DataPoint, maxPoints int) []DataPoint {
+	if maxPoints >= len(pts) || maxPoints <= 0 {
+		return pts
+	}
+
+	result := make([]DataPoint, 0, maxPoints)
+	for _, bucket := range timeBuckets(pts, maxPoints) {
+		var sumTime, sumValue float64
+		for _, p := range bucket {
+			sumTime += float64(p.Time)
+			sumValue += p.Value
+		}
+		n := float64(len(bucket))
+		result = append(result, DataPoint{
+			Time:  int64(math.Round(sumTime / n)),
+			Value: sumValue / n,
+		})
+	}
+	return result
+}
+
+// Split the time-ordered series into at most n non-empty runs of points
+// covering equal spans of time.
+func timeBuckets(pts []DataPoint, n int) [][]DataPoint {
+	first, last := pts[0].Time, pts[len(pts)-1].Time
+	span := float64(last-first) + 1
+	var result [][]DataPoint
+	start := 0
+	for b := 1; b <= n && start < len(pts); b++ {
+		end := start
+		limit := float64(first) + span*float64(b)/float64(n)
+		for end < len(pts) && (float64(pts[end].Time) < limit || b == n) {
+			end++
+		}
+		if end > start {
+			result = append(result, pts[start:end])
+		}
+		start = end
+	}
+	return result
+}
+
+// Downsample a series with the downsampler, keeping its gaps: runs of
+// values between null points are downsampled separately, each allotted its
+// share of the points, and consecutive nulls collapse to the first.  Series
+// with more runs and gaps than points allowed are instead split into equal
+// spans of time, each reduced to a point, null for spans of nulls alone.
+func downsampleNullable(ds downsampler, pts []DataPoint, maxPoints int) []DataPoint {
+	if maxPoints <= 0 || len(pts) <= maxPoints {
+		return pts
+	}
+	// runs[i] precedes gaps[i]
+	var runs [][]DataPoint
+	var gaps []DataPoint
+	start := 0
+	for i, pt := range pts {
+		if !pt.Null {
+			continue
+		}
+		if i > start || len(gaps) == 0 {
+			runs = append(runs, pts[start:i])
+			gaps = append(gaps, pt)
+		}
+		start = i + 1
+	}
+	runs = append(runs, pts[start:])
+	if len(gaps) == 0 {
+		return ds(pts, maxPoints)
+	}
+
+	values, nonEmpty := 0, 0
+	for _, run := range runs {
+		values += len(run)
+		if len(run) > 0 {
+			nonEmpty++
+		}
+	}
+	if nonEmpty+len(gaps) > maxPoints {
+		return downsampleSpans(ds, pts, maxPoints)
+	}
+	// each run keeps a point, sharing the rest of the points by length
+	extra := maxPoints - len(gaps) - nonEmpty
+	var result []DataPoint
+	for i, run := range runs {
+		if len(run) > 0 {
+			result = append(result, ds(run, 1+extra*len(run)/values)...)
+		}
+		if i < len(gaps) {
+			result = append(result, gaps[i])
+		}
+	}
+	return result
+}
+
+// Reduce a series with nulls to a point for each of maxPoints equal spans
+// of time, downsampling the values of the span, or null without values.
+func downsampleSpans(ds downsampler, pts []DataPoint, maxPoints int) []DataPoint {
+	result := make([]DataPoint, 0, maxPoints)
+	for _, bucket := range timeBuckets(pts, maxPoints) {
+		var values []DataPoint
+		for _, pt := range bucket {
+			if !pt.Null {
+				values = append(values, pt)
+			}
+		}
+		if len(values) == 0 {
+			result = append(result, DataPoint{Time: bucket[0].Time, Null: true})
+			continue
+		}
+		result = append(result, ds(values, 1)...)
+	}
+	return result
+}
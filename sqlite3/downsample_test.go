@@ -0,0 +1,126 @@
+package sqlite3
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func sineSeries(n int) []DataPoint {
+	pts := make([]DataPoint, n)
+	for i := range pts {
+		pts[i] = DataPoint{Time: int64(i * 1000), Value: math.Sin(float64(i) / 10)}
+	}
+	return pts
+}
+
+func checkDownsampled(t *testing.T, name string, pts []DataPoint, result []DataPoint, maxPoints int) {
+	if len(result) > maxPoints || len(result) == 0 {
+		t.Fatalf("%s: expected at most %d points, got %d", name, maxPoints, len(result))
+	}
+	for i := 1; i < len(result); i++ {
+		if result[i].Time < result[i-1].Time {
+			t.Fatalf("%s: points out of order %+v", name, result)
+		}
+	}
+	if result[0].Time > pts[len(pts)/maxPoints].Time ||
+		result[len(result)-1].Time < pts[len(pts)-1-len(pts)/maxPoints].Time {
+		t.Fatalf("%s: points do not span the series %+v", name, result)
+	}
+}
+
+func Test_lttb(t *testing.T) {
+	pts := sineSeries(1000)
+	result := lttb(pts, 100)
+	checkDownsampled(t, "lttb", pts, result, 100)
+	if len(result) != 100 || result[0] != pts[0] || result[99] != pts[999] {
+		t.Fatalf("lttb must keep the first and last of 100 points, got %+v", result)
+	}
+
+	short := sineSeries(10)
+	if !reflect.DeepEqual(short, lttb(short, 10)) {
+		t.Fatalf("lttb must not change series within the limit")
+	}
+}
+
+func Test_minMaxPerBucket(t *testing.T) {
+	pts := sineSeries(1000)
+	result := minMaxPerBucket(pts, 100)
+	checkDownsampled(t, "minmax", pts, result, 100)
+
+	var lo, hi float64
+	for _, p := range result {
+		lo = math.Min(lo, p.Value)
+		hi = math.Max(hi, p.Value)
+	}
+	if lo > -0.999 || hi < 0.999 {
+		t.Fatalf("minmax must keep the extremes of the series, got %f, %f", lo, hi)
+	}
+}
+
+func Test_avgPerBucket(t *testing.T) {
+	pts := []DataPoint{{Time: 0, Value: 1}, {Time: 1000, Value: 3}, {Time: 2000, Value: 5}, {Time: 3000, Value: 7}}
+	expected := []DataPoint{{Time: 500, Value: 2}, {Time: 2500, Value: 6}}
+	if result := avgPerBucket(pts, 2); !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected averages %+v, got %+v", expected, result)
+	}
+
+	pts = sineSeries(1000)
+	checkDownsampled(t, "avg", pts, avgPerBucket(pts, 100), 100)
+}
+
+func Test_downsampleNullable(t *testing.T) {
+	pts := sineSeries(100)
+	for i := 40; i < 50; i++ {
+		pts[i] = DataPoint{Time: pts[i].Time, Null: true}
+	}
+	result := downsampleNullable(lttb, pts, 20)
+	if len(result) > 20 {
+		t.Fatalf("expected at most 20 points, got %d", len(result))
+	}
+	nulls := 0
+	for _, pt := range result {
+		if pt.Null {
+			nulls++
+			if pt.Time != pts[40].Time {
+				t.Fatalf("expected the gap to start at %d, got %+v", pts[40].Time, pt)
+			}
+		}
+	}
+	if nulls != 1 {
+		t.Fatalf("expected the gap collapsed to a single null, got %d nulls", nulls)
+	}
+}
+
+func Test_downsampleNullableManyGaps(t *testing.T) {
+	pts := sineSeries(1000)
+	for i := 1; i < len(pts); i += 2 {
+		pts[i] = DataPoint{Time: pts[i].Time, Null: true}
+	}
+	for name, ds := range downsamplers {
+		if result := downsampleNullable(ds, pts, 100); len(result) > 100 {
+			t.Fatalf("expected %s to keep at most 100 points, got %d", name, len(result))
+		}
+	}
+
+	// a long gap stays null when spans are reduced
+	pts = sineSeries(1000)
+	for i := 0; i < len(pts); i++ {
+		if i%2 == 1 || (i >= 500 && i < 700) {
+			pts[i] = DataPoint{Time: pts[i].Time, Null: true}
+		}
+	}
+	result := downsampleNullable(lttb, pts, 50)
+	if len(result) > 50 {
+		t.Fatalf("expected at most 50 points, got %d", len(result))
+	}
+	nulls := 0
+	for _, pt := range result {
+		if pt.Null {
+			nulls++
+		}
+	}
+	if nulls == 0 {
+		t.Fatalf("expected the long gap kept as nulls, got %+v", result)
+	}
+}
@@ -0,0 +1,118 @@
+package sqlite3
+
+import (
+	"container/list"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+
+	gosqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// The name of the database/sql driver extending SQLite with the functions
+// needed to answer Grafana queries.
+const driverName = "sqlite3_grafana"
+
+func init() {
+	sql.Register(driverName, &gosqlite3.SQLiteDriver{
+		ConnectHook: func(conn *gosqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("regexp", regexpMatch, true); err != nil {
+				return err
+			}
+			for name, stat := range customAggregates {
+				stat := stat
+				newAggregator := func() *statsAggregator {
+					return &statsAggregator{stat: stat}
+				}
+				if err := conn.RegisterAggregator(name, newAggregator, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// The number of compiled patterns kept for the REGEXP operator.  Patterns
+// come from dashboards, so the cache keeps those used last rather than
+// growing with every pattern seen.
+const regexpCacheSize = 256
+
+// regexpLRU keeps the compiled patterns used last, up to its size.
+type regexpLRU struct {
+	mutex   sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type regexpEntry struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func newRegexpLRU(size int) *regexpLRU {
+	return &regexpLRU{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Compile the pattern, or find it compiled, evicting the pattern used least
+// recently when full.
+func (cache *regexpLRU) compile(pattern string) (*regexp.Regexp, error) {
+	cache.mutex.Lock()
+	if elem, ok := cache.entries[pattern]; ok {
+		cache.order.MoveToFront(elem)
+		cache.mutex.Unlock()
+		return elem.Value.(*regexpEntry).re, nil
+	}
+	cache.mutex.Unlock()
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if elem, ok := cache.entries[pattern]; ok {
+		// compiled meanwhile by another query
+		cache.order.MoveToFront(elem)
+		return elem.Value.(*regexpEntry).re, nil
+	}
+	cache.entries[pattern] = cache.order.PushFront(&regexpEntry{pattern: pattern, re: re})
+	if cache.order.Len() > cache.size {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*regexpEntry).pattern)
+	}
+	return re, nil
+}
+
+// Count the patterns kept.
+func (cache *regexpLRU) len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.order.Len()
+}
+
+var regexpCache = newRegexpLRU(regexpCacheSize)
+
+// Implement the SQLite REGEXP operator, "value REGEXP pattern", which
+// SQLite rewrites as regexp(pattern, value).  NULL values never match.
+func regexpMatch(pattern string, value interface{}) (bool, error) {
+	if value == nil {
+		return false, nil
+	}
+	if b, ok := value.([]byte); ok {
+		if b == nil {
+			return false, nil
+		}
+		value = string(b)
+	}
+
+	re, err := regexpCache.compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(fmt.Sprint(value)), nil
+}
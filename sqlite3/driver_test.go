@@ -0,0 +1,36 @@
+package sqlite3
+
+import (
+	"fmt"
+	"testing"
+)
+
+func Test_regexpLRU(t *testing.T) {
+	cache := newRegexpLRU(2)
+	first, err := cache.compile("^a")
+	if err != nil {
+		t.Fatalf(`Unexpected error compiling pattern "%v"`, err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := cache.compile(fmt.Sprintf("^a%d", i)); err != nil {
+			t.Fatalf(`Unexpected error compiling pattern "%v"`, err)
+		}
+		if cache.len() > 2 {
+			t.Fatalf("Expected at most 2 patterns kept, got %d", cache.len())
+		}
+	}
+	if again, _ := cache.compile("^a"); again == first {
+		t.Fatalf("Expected the pattern used least recently to be evicted")
+	}
+
+	kept, _ := cache.compile("^b")
+	cache.compile("^c")
+	cache.compile("^b")
+	cache.compile("^d")
+	if again, _ := cache.compile("^b"); again != kept {
+		t.Fatalf("Expected the pattern used last to be kept")
+	}
+	if _, err := cache.compile("("); err == nil || cache.len() > 2 {
+		t.Fatalf("Expected failure compiling a bad pattern, without keeping it")
+	}
+}
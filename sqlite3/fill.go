@@ -0,0 +1,185 @@
+package sqlite3
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
+	"github.com/pkg/errors"
+)
+
+// Modes of the fill() target option, besides numbers filling gaps with
+// themselves.
+var fillModes = NewSet("null", "previous", "linear")
+
+// Without MaxRows, filling may make points up to a multiple of the data
+// points requested, and at least a default number, before downsampling.
+const (
+	fillPointsPerDataPoint = 100
+	defaultMaxFillPoints   = 100000
+)
+
+// Check the mode of a fill() target option.
+func checkFill(mode string) error {
+	if fillModes.Contains(strings.ToLower(mode)) {
+		return nil
+	}
+	if _, err := strconv.ParseFloat(mode, 64); err != nil {
+		return errors.Errorf(`unknown fill mode "%s", expected null, previous, linear, or a number`, mode)
+	}
+	return nil
+}
+
+// Fill the missing intervals of a time-ordered series, with interval starts
+// spaced step millis apart and aligned to the points, over the intervals
+// overlapping the range from/to.  Points before the range are kept, as
+// intervals may start before it.  Intervals before the first point or after
+// the last are null for the previous and linear modes.
+func fillGaps(pts []DataPoint, from int64, to int64, step int64, mode string) []DataPoint {
+	if len(pts) == 0 || step <= 0 {
+		return pts
+	}
+	start := fillStart(pts, from, step)
+
+	mode = strings.ToLower(mode)
+	value, _ := strconv.ParseFloat(mode, 64)
+	fillPoint := func(t int64, prev *DataPoint, next *DataPoint) DataPoint {
+		switch {
+		case mode == "null":
+			return DataPoint{Time: t, Null: true}
+		case mode == "previous":
+			if prev == nil || prev.Null {
+				return DataPoint{Time: t, Null: true}
+			}
+			return DataPoint{Time: t, Value: prev.Value}
+		case mode == "linear":
+			if prev == nil || next == nil || prev.Null || next.Null {
+				return DataPoint{Time: t, Null: true}
+			}
+			slope := (next.Value - prev.Value) / float64(next.Time-prev.Time)
+			return DataPoint{Time: t, Value: prev.Value + slope*float64(t-prev.Time)}
+		default:
+			return DataPoint{Time: t, Value: value}
+		}
+	}
+
+	var result []DataPoint
+	var prev *DataPoint
+	i := 0
+	for t := start; t < to || i < len(pts); {
+		switch {
+		case i < len(pts) && pts[i].Time <= t:
+			// points off the interval grid are kept as is
+			result = append(result, pts[i])
+			if pts[i].Time == t {
+				t += step
+			}
+			prev = &pts[i]
+			i++
+		case t < to:
+			var next *DataPoint
+			if i < len(pts) {
+				next = &pts[i]
+			}
+			result = append(result, fillPoint(t, prev, next))
+			t += step
+		default:
+			t = pts[i].Time
+		}
+	}
+	return result
+}
+
+// Find the start of the first interval filled, aligned to the points, or the
+// first point if earlier.
+func fillStart(pts []DataPoint, from int64, step int64) int64 {
+	phase := floorMod(pts[0].Time, step)
+	start := phase + floorDiv(from-phase, step)*step
+	if pts[0].Time < start {
+		start = pts[0].Time
+	}
+	return start
+}
+
+// Count the points, at most, of the series filled by fillGaps, without
+// filling it.
+func fillCount(pts []DataPoint, from int64, to int64, step int64) int64 {
+	if len(pts) == 0 || step <= 0 {
+		return int64(len(pts))
+	}
+	count := int64(len(pts))
+	if start := fillStart(pts, from, step); to > start {
+		count += (to-start)/step + 1
+	}
+	return count
+}
+
+// Find the smallest time between consecutive points of the series, to fill
+// series intervalized by t() expressions, or zero without two points.
+func smallestStep(series map[string][]DataPoint) int64 {
+	var step int64
+	for _, pts := range series {
+		for i := 1; i < len(pts); i++ {
+			if d := pts[i].Time - pts[i-1].Time; d > 0 && (step == 0 || d < step) {
+				step = d
+			}
+		}
+	}
+	return step
+}
+
+func floorDiv(a int64, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+func floorMod(a int64, b int64) int64 {
+	return a - floorDiv(a, b)*b
+}
+
+// Fill the missing intervals of each series read for the target over the
+// time range, spacing intervals by the i() or auto interval, or the smallest
+// step between points for t() expressions.  Filling fails rather than make
+// more than maxPoints points over all series.
+func fillSeries(series map[string][]DataPoint, tq *targetQuery, fromTo *QueryRange, maxPoints int64) error {
+	from, err := timecodex.StringToTime(fromTo.From)
+	if err != nil {
+		return errors.Wrap(err, "fill from time")
+	}
+	to, err := timecodex.StringToTime(fromTo.To)
+	if err != nil {
+		return errors.Wrap(err, "fill to time")
+	}
+	step := int64(tq.interval / time.Millisecond)
+	if step == 0 {
+		step = smallestStep(series)
+	}
+	fromMillis, toMillis := from.UnixNano()/1000000, to.UnixNano()/1000000
+	var count int64
+	for _, pts := range series {
+		count += fillCount(pts, fromMillis, toMillis, step)
+		if count > maxPoints {
+			return errors.Errorf("fill would make more than %d points, use a longer interval or range", maxPoints)
+		}
+	}
+	for name, pts := range series {
+		series[name] = fillGaps(pts, fromMillis, toMillis, step, tq.fill)
+	}
+	return nil
+}
+
+// Bound the points made by filling the series of a query, by the rows the
+// query may read, or else by the data points requested.
+func (seriesMan *sqliteTimeSeriesManager) maxFillPoints(opts *TimeSeriesQueryOpts) int64 {
+	if seriesMan.opts.MaxRows > 0 {
+		return int64(seriesMan.opts.MaxRows)
+	}
+	if opts != nil && opts.MaxDataPoints > 0 && int64(opts.MaxDataPoints)*fillPointsPerDataPoint > defaultMaxFillPoints {
+		return int64(opts.MaxDataPoints) * fillPointsPerDataPoint
+	}
+	return defaultMaxFillPoints
+}
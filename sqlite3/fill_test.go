@@ -0,0 +1,96 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func Test_fillGaps(t *testing.T) {
+	pts := []DataPoint{{Time: 2000, Value: 2}, {Time: 5000, Value: 8}}
+	null := func(t int64) DataPoint { return DataPoint{Time: t, Null: true} }
+	cases := map[string][]DataPoint{
+		"null": {null(1000), {Time: 2000, Value: 2}, null(3000), null(4000), {Time: 5000, Value: 8}, null(6000)},
+		"0": {{Time: 1000}, {Time: 2000, Value: 2}, {Time: 3000}, {Time: 4000}, {Time: 5000, Value: 8},
+			{Time: 6000}},
+		"previous": {null(1000), {Time: 2000, Value: 2}, {Time: 3000, Value: 2}, {Time: 4000, Value: 2},
+			{Time: 5000, Value: 8}, {Time: 6000, Value: 8}},
+		"linear": {null(1000), {Time: 2000, Value: 2}, {Time: 3000, Value: 4}, {Time: 4000, Value: 6},
+			{Time: 5000, Value: 8}, null(6000)},
+	}
+	for mode, expected := range cases {
+		if result := fillGaps(pts, 1500, 7000, 1000, mode); !reflect.DeepEqual(expected, result) {
+			t.Fatalf("expected fill(%s) to give %+v, got %+v", mode, expected, result)
+		}
+	}
+
+	// points off the interval grid are kept
+	pts = []DataPoint{{Time: 0, Value: 1}, {Time: 1500, Value: 2}, {Time: 3000, Value: 3}}
+	expected := []DataPoint{{Time: 0, Value: 1}, null(1000), {Time: 1500, Value: 2}, null(2000),
+		{Time: 3000, Value: 3}}
+	if result := fillGaps(pts, 0, 3500, 1000, "null"); !reflect.DeepEqual(expected, result) {
+		t.Fatalf("expected off-grid points kept as %+v, got %+v", expected, result)
+	}
+	if count := fillCount(pts, 0, 3500, 1000); count < int64(len(expected)) {
+		t.Fatalf("expected fill count at least %d, got %d", len(expected), count)
+	}
+	if count := fillCount(pts, 0, 50000000, 1); count < 50000000 {
+		t.Fatalf("expected fill count bounding long ranges, got %d", count)
+	}
+}
+
+func Test_GetTimeSeriesFill(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	queries := []string{
+		"CREATE TABLE up (ts INT, tag TEXT, x INT)",
+		"INSERT INTO up VALUES (1585699200, 'a', 1), (1585702800, 'b', 2), (1585710000, 'a', 3)",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	tsm := sqliteTimeSeriesManager{db: db, table: "up", timeColumn: "ts"}
+	fromTo := QueryRange{From: "2020-04-01T00:00:00Z", To: "2020-04-01T04:00:00Z"}
+
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "sum(x) tag i(1h) fill(null)", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error filling time series "%+v"`, err)
+	}
+	expected := map[string][]DataPoint{
+		"a": {{Time: 1585699200000, Value: 1}, {Time: 1585702800000, Null: true},
+			{Time: 1585706400000, Null: true}, {Time: 1585710000000, Value: 3}},
+		"b": {{Time: 1585699200000, Null: true}, {Time: 1585702800000, Value: 2},
+			{Time: 1585706400000, Null: true}, {Time: 1585710000000, Null: true}},
+	}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Fatalf("Expected filled series %+v, got %+v", expected, ts)
+	}
+
+	// t() intervals are spaced by the smallest step between points
+	if err := tsm.GetTimeSeries(context.Background(), "sum(x) t(3600*(?/3600)) fill(0)", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error filling time series "%+v"`, err)
+	}
+	expected = map[string][]DataPoint{
+		"sum(x)": {{Time: 1585699200000, Value: 1}, {Time: 1585702800000, Value: 2},
+			{Time: 1585706400000}, {Time: 1585710000000, Value: 3}},
+	}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Fatalf("Expected filled series %+v, got %+v", expected, ts)
+	}
+
+	// filling fails rather than make unbounded points
+	fromTo = QueryRange{From: "2019-04-01T00:00:00Z", To: "2020-04-01T04:00:00Z"}
+	if err := tsm.GetTimeSeries(context.Background(), "sum(x) i(1s) fill(0)", &fromTo, nil, &ts); err == nil {
+		t.Fatalf("Expected error filling a year of seconds, got %d points", len(ts["sum(x)"]))
+	}
+	tsm.opts.MaxRows = 10
+	fromTo = QueryRange{From: "2020-04-01T00:00:00Z", To: "2020-04-01T04:00:00Z"}
+	if err := tsm.GetTimeSeries(context.Background(), "sum(x) i(1m) fill(0)", &fromTo, nil, &ts); err == nil {
+		t.Fatal("Expected error filling more points than max rows")
+	}
+}
@@ -0,0 +1,390 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
+	"github.com/pkg/errors"
+)
+
+// Targets starting with rawSQLPrefix hold a SELECT statement to run as is,
+// after expanding macros.
+const rawSQLPrefix = "sql:"
+
+// Find the raw SQL query held by the target, if any.
+func rawSQL(target string) (string, bool) {
+	target = strings.TrimSpace(target)
+	if len(target) < len(rawSQLPrefix) || !strings.EqualFold(target[:len(rawSQLPrefix)], rawSQLPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(target[len(rawSQLPrefix):]), true
+}
+
+// Macros are written as Grafana SQL datasources do, e.g. $__timeFilter(ts).
+var macroPattern = regexp.MustCompile(`\$__(\w+)(?:\(([^)]*)\))?`)
+
+// Expand the macros in a raw SQL query, returning the query and the
+// parameters bound by the macros:
+//   - $__timeFilter(col) restricts the column to the time range,
+//   - $__timeGroup(col, duration) rounds the column down to a multiple of the
+//     duration, or of the query interval for $__interval,
+//   - $__timeFrom() and $__timeTo() are the time range in the encoding of the
+//     time column,
+//   - $__unixEpochFrom() and $__unixEpochTo() are the time range in epoch
+//     seconds, and
+//   - $__interval_ms is the query interval in milliseconds.
+//
+// Columns are those of the table, read as the time column would be.
+func (seriesMan *sqliteTimeSeriesManager) expandMacros(query string, fromTo *QueryRange, opts *TimeSeriesQueryOpts) (string, []interface{}, error) {
+	var schema []TagKey
+	if err := seriesMan.getSchema(seriesMan.table, &schema); err != nil {
+		return "", nil, err
+	}
+	timeColumn := func(name string) (string, error) {
+		column := findColumn(schema, strings.Trim(name, `"`))
+		if column == "" {
+			return "", errors.Errorf(`unknown column "%s" in table %s`, name, seriesMan.table)
+		}
+		return column, nil
+	}
+	epochSeconds := func(timeStr string) (string, error) {
+		t, err := timecodex.StringToTime(timeStr)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d", t.Unix()), nil
+	}
+
+	var args []interface{}
+	var expandErr error
+	expanded := macroPattern.ReplaceAllStringFunc(query, func(macro string) string {
+		if expandErr != nil {
+			return ""
+		}
+		match := macroPattern.FindStringSubmatch(macro)
+		name := match[1]
+		var macroArgs []string
+		if strings.TrimSpace(match[2]) != "" {
+			for _, arg := range strings.Split(match[2], ",") {
+				macroArgs = append(macroArgs, strings.TrimSpace(arg))
+			}
+		}
+		checkArgs := func(n int) bool {
+			if len(macroArgs) != n {
+				expandErr = errors.Errorf("macro $__%s expects %d arguments, but found %d", name, n, len(macroArgs))
+			}
+			return expandErr == nil
+		}
+
+		switch name {
+		case "timeFilter":
+			if !checkArgs(1) {
+				return ""
+			}
+			column, err := timeColumn(macroArgs[0])
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			from, err := seriesMan.formatUserTimeForQuery(seriesMan.table, column, fromTo.From, false)
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			to, err := seriesMan.formatUserTimeForQuery(seriesMan.table, column, fromTo.To, true)
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			args = append(args, from, to)
+			return fmt.Sprintf("(%s >= ? AND %s < ?)", quoteIdent(column), quoteIdent(column))
+		case "timeGroup":
+			if !checkArgs(2) {
+				return ""
+			}
+			column, err := timeColumn(macroArgs[0])
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			var d time.Duration
+			if macroArgs[1] == "$__interval" {
+				d, err = seriesMan.autoInterval(opts)
+			} else {
+				d, err = timecodex.ParseDuration(macroArgs[1])
+			}
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			expr, err := seriesMan.timeBucketExpr(column, d)
+			expandErr = err
+			return expr
+		case "timeFrom", "timeTo":
+			if !checkArgs(0) {
+				return ""
+			}
+			timeStr := fromTo.From
+			if name == "timeTo" {
+				timeStr = fromTo.To
+			}
+			t, err := seriesMan.formatUserTimeForQuery(seriesMan.table, seriesMan.timeColumn, timeStr, name == "timeTo")
+			if err != nil {
+				expandErr = err
+				return ""
+			}
+			args = append(args, t)
+			return "?"
+		case "unixEpochFrom", "unixEpochTo":
+			if !checkArgs(0) {
+				return ""
+			}
+			timeStr := fromTo.From
+			if name == "unixEpochTo" {
+				timeStr = fromTo.To
+			}
+			seconds, err := epochSeconds(timeStr)
+			expandErr = err
+			return seconds
+		case "interval_ms":
+			if match[2] != "" || strings.HasSuffix(macro, ")") {
+				expandErr = errors.New("macro $__interval_ms takes no arguments")
+				return ""
+			}
+			if opts == nil || opts.IntervalMs <= 0 {
+				expandErr = errors.New("macro $__interval_ms requires the query intervalMs")
+				return ""
+			}
+			return fmt.Sprintf("%d", opts.IntervalMs)
+		default:
+			expandErr = errors.Errorf("unknown macro $__%s", name)
+			return ""
+		}
+	})
+	if expandErr != nil {
+		return "", nil, errors.Wrap(expandErr, "expand raw SQL")
+	}
+	return expanded, args, nil
+}
+
+// Run a raw SQL query on a connection refusing writes, returning the rows
+// and a function to release the connection after closing the rows.
+func (seriesMan *sqliteTimeSeriesManager) queryRawSQL(ctx context.Context, query string, fromTo *QueryRange, opts *TimeSeriesQueryOpts) (*sql.Rows, func(), error) {
+	expanded, args, err := seriesMan.expandMacros(query, fromTo, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkSingleSelect(expanded); err != nil {
+		return nil, nil, errors.Wrap(err, "raw SQL")
+	}
+	sugar.Debugw("raw SQL query", "query", expanded, "args", args)
+
+	conn, err := seriesMan.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// read-only connections stay so, others are restored after the query
+	var queryOnly bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA query_only").Scan(&queryOnly); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !queryOnly {
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	release := func() {
+		if !queryOnly {
+			// restore the connection even if the query was cancelled
+			conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+		}
+		conn.Close()
+	}
+	rows, err := conn.QueryContext(ctx, expanded, args...)
+	if err != nil {
+		release()
+		return nil, nil, errors.Wrap(err, "bad raw SQL query")
+	}
+	return rows, func() {
+		rows.Close()
+		release()
+	}, nil
+}
+
+// Read the time series selected by a raw SQL query, taking the first column
+// as the time, the second as the value, and any others as tags, as for
+// GetTimeSeries.
+func (seriesMan *sqliteTimeSeriesManager) getRawTimeSeries(ctx context.Context, query string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *map[string][]DataPoint) error {
+	timeReader := seriesMan.anyTimeToMillis()
+	rows, release, err := seriesMan.queryRawSQL(ctx, query, fromTo, opts)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(columns) < 2 {
+		return errors.Errorf("raw SQL must select time and value columns, but selects %d", len(columns))
+	}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(interface{})
+	}
+	rowCount := 0
+	result := make(map[string][]DataPoint)
+	for rows.Next() {
+		rowCount++
+		if err := seriesMan.checkRowCount(rowCount); err != nil {
+			return err
+		}
+		if err := rows.Scan(values...); err != nil {
+			return errors.Errorf("Cannot scan row: %v", err)
+		}
+		timeMillis, err := timeReader(scanPointer(values[0]))
+		if err != nil {
+			return err
+		}
+		value, err := valueReader(scanPointer(values[1]))
+		if err != nil {
+			return err
+		}
+		tag := columns[1]
+		if len(columns) > 2 {
+			tags := make([]string, len(columns)-2)
+			for i, v := range values[2:] {
+				if p := scanPointer(v); p != nil {
+					tags[i] = toString(p)
+				}
+			}
+			tag = strings.Join(tags, " ")
+		}
+		result[tag] = append(result[tag], DataPoint{Time: timeMillis, Value: value.Float64, Null: !value.Valid})
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read raw SQL rows")
+	}
+
+	if downsample, _ := getDownsampler(""); opts != nil && opts.MaxDataPoints > 0 {
+		for tag, pts := range result {
+			result[tag] = downsampleNullable(downsample, pts, int(opts.MaxDataPoints))
+		}
+	}
+	*dest = result
+	sugar.Debugw("raw SQL timeseries completed", "#rows", rowCount)
+	return nil
+}
+
+// Read the rows selected by a raw SQL query, taking the first column as the
+// time, as for GetTable.
+func (seriesMan *sqliteTimeSeriesManager) getRawTable(ctx context.Context, query string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *Table) error {
+	timeReader := seriesMan.anyTimeToMillis()
+	rows, release, err := seriesMan.queryRawSQL(ctx, query, fromTo, opts)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(interface{})
+	}
+	result := Table{Rows: [][]interface{}{}}
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+		if err := seriesMan.checkRowCount(rowCount); err != nil {
+			return err
+		}
+		if err := rows.Scan(values...); err != nil {
+			return errors.Errorf("Cannot scan row: %v", err)
+		}
+		pointers := make([]interface{}, len(values))
+		for i, v := range values {
+			pointers[i] = scanPointer(v)
+		}
+		if result.Columns == nil {
+			result.Columns = tableColumns(columns, pointers)
+		}
+		row := make([]interface{}, len(values))
+		if row[0], err = timeReader(pointers[0]); err != nil {
+			return err
+		}
+		for i, p := range pointers[1:] {
+			if p != nil {
+				row[i+1] = reflect.ValueOf(p).Elem().Interface()
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read raw SQL rows")
+	}
+	if result.Columns == nil {
+		result.Columns = tableColumns(columns, make([]interface{}, len(columns)))
+	}
+	*dest = result
+	return nil
+}
+
+// Build a function reading times of any encoding from raw SQL query results
+// as epoch millis.  Numbers are read in the encoding of the time column
+// where numeric, as GetTimeSeries reads them.  Otherwise, integers are taken
+// for epoch times of the unit guessed from their magnitude, and fractional
+// numbers for Julian days when in their range, as julianday() returns, or
+// else for epoch times of the unit guessed, keeping fractions of the unit.
+// Build it before querying, as finding the encoding reads the table.
+func (seriesMan *sqliteTimeSeriesManager) anyTimeToMillis() func(input interface{}) (int64, error) {
+	readNumber := func(input interface{}) (int64, error) {
+		switch v := input.(type) {
+		case *int64:
+			return timecodex.NumberToTime(*v).UnixNano() / 1000000, nil
+		case *float64:
+			if *v >= julianMinimum && *v < julianMaximum {
+				return readJulianMillis(v)
+			}
+			scale, s := timecodex.NumberToScalar(int64(*v))
+			if s {
+				return int64(math.Round(*v * float64(scale))), nil
+			}
+			return int64(math.Round(*v / float64(scale))), nil
+		default:
+			return 0, errors.Errorf("cannot read time from %v", input)
+		}
+	}
+	if seriesMan.table != "" && seriesMan.timeColumn != "" {
+		if encoding, err := seriesMan.timeEncoding(seriesMan.table, seriesMan.timeColumn); err == nil {
+			switch encoding.kind {
+			case julianEncoding:
+				readNumber = readJulianMillis
+			case epochEncoding:
+				readNumber = epochToMillis(encoding.unit)
+			}
+		}
+	}
+	return func(input interface{}) (int64, error) {
+		switch v := input.(type) {
+		case *int64, *float64:
+			return readNumber(v)
+		case *string:
+			return seriesMan.dateTimeToMillis(v)
+		default:
+			return 0, errors.Errorf("cannot read time from %v", input)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_rawSQL(t *testing.T) {
+	if query, ok := rawSQL("  SQL: SELECT 1"); !ok || query != "SELECT 1" {
+		t.Fatalf(`Expected raw SQL "SELECT 1", got "%s", %v`, query, ok)
+	}
+	if _, ok := rawSQL("x tag"); ok {
+		t.Fatalf("Expected target not to be raw SQL")
+	}
+}
+
+func Test_expandMacros(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "1", To: "10"}
+	opts := TimeSeriesQueryOpts{IntervalMs: 2000}
+
+	query, args, err := tsm.expandMacros(
+		"SELECT $__timeGroup(ts, $__interval), avg(x), $__interval_ms FROM tsTab "+
+			"WHERE $__timeFilter(ts) AND ts < $__unixEpochTo() GROUP BY 1", &fromTo, &opts)
+	expected := `SELECT 2*("ts"/2), avg(x), 2000 FROM tsTab WHERE ("ts" >= ? AND "ts" < ?) AND ts < 10 GROUP BY 1`
+	if err != nil || query != expected {
+		t.Fatalf(`Expected query "%s", got "%s", %v`, expected, query, err)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(1), int64(10)}) {
+		t.Fatalf(`Unexpected args "%+v"`, args)
+	}
+
+	for _, bad := range []string{"$__timeFilter(nope)", "$__timeGroup(ts)", "$__timeFrom(ts)", "$__nope()"} {
+		if _, _, err := tsm.expandMacros(bad, &fromTo, &opts); err == nil {
+			t.Fatalf(`Expected error expanding "%s"`, bad)
+		}
+	}
+}
+
+func Test_GetRawTimeSeries(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "1", To: "4"}
+
+	var ts map[string][]DataPoint
+	err := tsm.GetTimeSeries(context.Background(), "sql: SELECT ts, x * 2 AS y, tag FROM tsTab WHERE $__timeFilter(ts) ORDER BY ts",
+		&fromTo, nil, &ts)
+	if err != nil {
+		t.Fatalf(`Unexpected raw SQL error "%+v"`, err)
+	}
+	expected := map[string][]DataPoint{
+		"a": {{Time: 1000, Value: 200}, {Time: 3000, Value: 600}},
+		"b": {{Time: 2000, Value: 400}},
+	}
+	if !reflect.DeepEqual(ts, expected) {
+		t.Fatalf(`Expected timeseries "%+v", got "%+v"`, expected, ts)
+	}
+
+	err = tsm.GetTimeSeries(context.Background(), "sql: SELECT dt, f FROM tsTab ORDER BY dt", &fromTo, nil, &ts)
+	if err != nil || len(ts["f"]) != 4 || ts["f"][0].Time != 1585699200000 {
+		t.Fatalf(`Unexpected datetime timeseries "%+v", %v`, ts, err)
+	}
+
+	var table Table
+	if err := tsm.GetTable(context.Background(), "sql: SELECT ts, tag FROM tsTab WHERE $__timeFilter(ts)", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected raw SQL table error "%+v"`, err)
+	}
+	if len(table.Rows) != 3 || table.Columns[1] != (TableColumn{Text: "tag", Type: "string"}) ||
+		!reflect.DeepEqual(table.Rows[0], []interface{}{int64(1000), "a"}) {
+		t.Fatalf(`Unexpected raw SQL table "%+v"`, table)
+	}
+}
+
+func Test_GetRawTimeSeriesFractionalTimes(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "dt"}
+	fromTo := QueryRange{From: "2020-04-01", To: "2020-04-05"}
+
+	var ts map[string][]DataPoint
+	err := tsm.GetTimeSeries(context.Background(),
+		"sql: SELECT julianday('2020-04-01 12:00:00.250') AS jd, 1 AS jdValue", &fromTo, nil, &ts)
+	if err != nil || len(ts["jdValue"]) != 1 || ts["jdValue"][0].Time != 1585742400250 {
+		t.Fatalf(`Expected Julian day at 1585742400250, got "%+v", %v`, ts, err)
+	}
+	err = tsm.GetTimeSeries(context.Background(), "sql: SELECT 1585742400.25 AS s, 1 AS sValue", &fromTo, nil, &ts)
+	if err != nil || len(ts["sValue"]) != 1 || ts["sValue"][0].Time != 1585742400250 {
+		t.Fatalf(`Expected fractional seconds at 1585742400250, got "%+v", %v`, ts, err)
+	}
+
+	// numbers are read in the encoding of a numeric time column
+	db.Exec("CREATE TABLE jdTab (jd REAL, x INT)")
+	db.Exec("INSERT INTO jdTab VALUES (2458941.0, 1)")
+	tsm = sqliteTimeSeriesManager{db: db, table: "jdTab", timeColumn: "jd"}
+	err = tsm.GetTimeSeries(context.Background(), "sql: SELECT jd, x FROM jdTab", &fromTo, nil, &ts)
+	if err != nil || len(ts["x"]) != 1 || ts["x"][0].Time != 1585742400000 {
+		t.Fatalf(`Expected Julian day column at 1585742400000, got "%+v", %v`, ts, err)
+	}
+}
+
+func Test_GetRawTimeSeriesEpochUnits(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	db.SetMaxOpenConns(1)
+	queries := []string{
+		"CREATE TABLE m (us INT, ns INT, v INT)",
+		"INSERT INTO m VALUES (1585742400000000, 1585742400000000000, 1)",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	fromTo := QueryRange{From: "2020-04-01T00:00:00Z", To: "2020-04-02T00:00:00Z"}
+	for column, format := range map[string]string{"us": "epoch_us", "ns": "epoch_ns"} {
+		tsm := sqliteTimeSeriesManager{db: db, table: "m", timeColumn: column,
+			opts: Options{TimeFormats: map[TableTimeColumn]string{{Table: "m", TimeColumn: column}: format}}}
+		var ts map[string][]DataPoint
+		query := fmt.Sprintf("sql: SELECT %s, v FROM m WHERE $__timeFilter(%s)", column, column)
+		if err := tsm.GetTimeSeries(context.Background(), query, &fromTo, nil, &ts); err != nil {
+			t.Fatalf(`Unexpected raw SQL error for %s "%+v"`, format, err)
+		}
+		if len(ts["v"]) != 1 || ts["v"][0].Time != 1585742400000 {
+			t.Fatalf(`Expected %s time at 1585742400000, got "%+v"`, format, ts)
+		}
+	}
+}
+
+func Test_RawSQLIsReadOnly(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "1", To: "4"}
+
+	var table Table
+	if err := tsm.GetTable(context.Background(), "sql: DELETE FROM tsTab", &fromTo, nil, &table); err == nil ||
+		!strings.Contains(err.Error(), "DELETE") {
+		t.Fatalf(`Expected failure deleting rows through raw SQL, but got "%v"`, err)
+	}
+	var count int
+	if db.QueryRow("SELECT count(*) FROM tsTab").Scan(&count); count != 4 {
+		t.Fatalf("Expected raw SQL not to delete rows, but found %d", count)
+	}
+	if _, err := db.Exec("INSERT INTO tsTab (ts, x) VALUES (5, 500)"); err != nil {
+		t.Fatalf(`Expected connections to be writable after raw SQL, but got "%v"`, err)
+	}
+
+	// read-only connections stay read-only after raw SQL
+	dbFileName := tempFileName(t)
+	defer os.Remove(dbFileName)
+	db, err := sql.Open(driverName, dbFileName)
+	if err != nil {
+		t.Fatalf(`Cannot create file-backed db at %s: "%+v"`, dbFileName, err)
+	}
+	db.Exec("CREATE TABLE tsTab (ts INT, x INT)")
+	db.Close()
+	tsm.db, _ = sql.Open(driverName, "file:"+dbFileName+"?_query_only=1")
+	tsm.db.SetMaxOpenConns(1)
+	if err := tsm.GetTable(context.Background(), "sql: SELECT 1", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected raw SQL failure "%v"`, err)
+	}
+	if _, err := tsm.db.Exec("CREATE TABLE t (x INT)"); err == nil {
+		t.Fatalf("Expected read-only connection to fail writes after raw SQL")
+	}
+}
@@ -1,9 +1,16 @@
 package sqlite3
 
+import (
+	"context"
+	"time"
+)
+
 // DataPoint is a time-scalar tuple for reporting observations back to Grafana.
+// Null points mark times without a value, such as gaps filled with nulls.
 type DataPoint struct {
 	Time  int64
 	Value float64
+	Null  bool
 }
 
 // TagKey represents a column name and the declared type of column values.
@@ -12,6 +19,39 @@ type TagKey struct {
 	Text string ` json:"text"`
 }
 
+// TableColumn names a column in a table query result, along with the
+// Grafana type of its values.
+type TableColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// Table holds the result of a table query for Grafana, a list of described
+// columns and the rows of values under them.
+type Table struct {
+	Columns []TableColumn
+	Rows    [][]interface{}
+}
+
+// AnnotationSource names the table and columns holding events to display
+// as Grafana annotations.  The TimeEndColumn and TagsColumn are optional.
+type AnnotationSource struct {
+	Table         string
+	TimeColumn    string
+	TimeEndColumn string
+	TextColumn    string
+	TagsColumn    string
+}
+
+// Annotation is an event to mark on Grafana graphs.  Events spanning a time
+// region have a non-zero TimeEnd.
+type Annotation struct {
+	Time    int64
+	TimeEnd int64
+	Text    string
+	Tags    []string
+}
+
 // QueryRangeRaw stores the grafana time query range as entered by user.
 // The range values typically have relative values, such as "now-10d".
 type QueryRangeRaw struct {
@@ -33,6 +73,25 @@ type QueryTarget struct {
 	Target string
 	RefID  string
 	Type   string
+	Data   *TargetData
+}
+
+// TargetData holds the additional JSON data of a target, naming the table
+// and time column for targets queried through a Database.  Targets without
+// text may instead state their query in the data, in the terms of the text:
+// value expressions, tag columns, the bucket, "auto" or a duration as for
+// i(), filters as for ad hoc filters, and the fill mode, along with the time
+// order of rows, "asc" or "desc", and a limit on the rows read, 0 for none.
+type TargetData struct {
+	Table      string
+	TimeColumn string
+	Values     []string
+	Tags       []string
+	Bucket     string
+	Filters    []QueryFilter
+	Fill       string
+	Order      string
+	Limit      int
 }
 
 // QueryFilter stores a query limiter requested by Grafana.
@@ -42,18 +101,43 @@ type QueryFilter struct {
 	Value    string
 }
 
-// TimeSeriesQueryOpts holds options for a query.  Currently, only the
-// MaxDataPoints field is respected by sqlite32grafana.
+// TimeSeriesQueryOpts holds options for a query.  The Interval and
+// IntervalMs fields, suggested by Grafana for the time range and panel
+// width, size the buckets of targets with the "auto" option.  Data holds
+// the data of the target, queried in place of empty target text when
+// stating values.
 type TimeSeriesQueryOpts struct {
 	Interval      string
+	IntervalMs    int64
 	MaxDataPoints int32
 	Filters       []QueryFilter
+	Data          *TargetData
+}
+
+// Options control how a TimeSeriesManager opens and reads its table.
+type Options struct {
+	// Writable opens the database file with write access, rather than
+	// read-only.
+	Writable bool
+	// MaxRows fails queries reading more rows, 0 for no limit.
+	MaxRows int
+	// Location interprets stored text times lacking a time zone, UTC if nil.
+	Location *time.Location
+	// TimeFormats states the encoding of time columns, instead of inferring
+	// it from the column type and values: "epoch_s", "epoch_ms", "epoch_us",
+	// or "epoch_ns" for epoch times in the unit, "epoch" to infer the unit,
+	// "julian" for Julian days, "iso8601" for ISO-8601 and SQLite datetime()
+	// text, or else a Go time layout for text in the layout.
+	TimeFormats map[TableTimeColumn]string
 }
 
 // TimeSeriesManager exposes calls available to ReST end points to query
-// SQLite table columns to Grafana.
+// SQLite table columns to Grafana.  Cancelling the context interrupts the
+// SQLite statement of a call.
 type TimeSeriesManager interface {
-	GetTimeSeries(target string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *map[string][]DataPoint) error
-	GetTagKeys(tableName string, dest *[]TagKey) error
-	GetTagValues(tableName string, key string, dest *[]string) error
+	GetTimeSeries(ctx context.Context, target string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *map[string][]DataPoint) error
+	GetTable(ctx context.Context, target string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *Table) error
+	GetAnnotations(ctx context.Context, source *AnnotationSource, fromTo *QueryRange, dest *[]Annotation) error
+	GetTagKeys(ctx context.Context, target string, dest *[]TagKey) error
+	GetTagValues(ctx context.Context, tableName string, key string, limit int, dest *[]string) error
 }
@@ -0,0 +1,86 @@
+package sqlite3
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Keywords of statements modifying the database or the connection, rejected
+// anywhere in a query outside of strings and quoted identifiers, unless
+// naming a function, as for replace().
+var modifyingKeywords = NewSet("alter", "analyze", "attach", "create", "delete", "detach", "drop",
+	"insert", "pragma", "reindex", "replace", "update", "vacuum")
+
+// Check that the query is a single SELECT statement, possibly starting with a
+// WITH clause, so that queries can neither modify nor lock the database.
+func checkSingleSelect(query string) error {
+	runes := []rune(query)
+	first := ""
+	statementEnded := false
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			for i += 2; i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/'); i++ {
+			}
+			if i+1 >= len(runes) {
+				return errors.New("unterminated comment in query")
+			}
+			i += 2
+		case r == ';':
+			statementEnded = true
+			i++
+		case statementEnded:
+			return errors.New("query must be a single statement")
+		case r == '\'' || r == '"' || r == '`' || r == '[':
+			closing := r
+			if r == '[' {
+				closing = ']'
+			}
+			for i++; i < len(runes) && runes[i] != closing; i++ {
+			}
+			if i >= len(runes) {
+				return errors.Errorf("unterminated %c in query", r)
+			}
+			// doubled quotes resume the quoted text on the next pass
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(runes) && (runes[i] == '_' || runes[i] == '$' ||
+				unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i])) {
+				i++
+			}
+			word := strings.ToLower(string(runes[start:i]))
+			if first == "" {
+				first = word
+				if first != "select" && first != "with" {
+					return errors.Errorf(`query must be a SELECT statement, not %s`, strings.ToUpper(first))
+				}
+			}
+			next := i
+			for next < len(runes) && unicode.IsSpace(runes[next]) {
+				next++
+			}
+			if modifyingKeywords.Contains(word) && !(next < len(runes) && runes[next] == '(') {
+				return errors.Errorf(`query must only read the database, but has %s`, strings.ToUpper(word))
+			}
+		default:
+			if first == "" {
+				return errors.New("query must be a SELECT statement")
+			}
+			i++
+		}
+	}
+	if first == "" {
+		return errors.New("query must be a SELECT statement")
+	}
+	return nil
+}
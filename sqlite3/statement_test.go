@@ -0,0 +1,39 @@
+package sqlite3
+
+import (
+	"testing"
+)
+
+func Test_checkSingleSelect(t *testing.T) {
+	accepted := []string{
+		"SELECT ts, x FROM tsTab",
+		"  select replace(tag, 'a', 'b') FROM tsTab; -- trailing comment",
+		"WITH t AS (SELECT 1) SELECT * FROM t;",
+		`SELECT "delete", 'drop table; x', [update] /* insert; */ FROM tsTab`,
+		"SELECT 'it''s', \"a\"\"b\" FROM tsTab",
+	}
+	for _, query := range accepted {
+		if err := checkSingleSelect(query); err != nil {
+			t.Fatalf(`expected to accept "%s", but got "%v"`, query, err)
+		}
+	}
+
+	rejected := []string{
+		"",
+		"-- SELECT",
+		"DELETE FROM tsTab",
+		"SELECT 1; DELETE FROM tsTab",
+		"SELECT 1;; SELECT 2",
+		"WITH t AS (SELECT 1) DELETE FROM tsTab",
+		"PRAGMA query_only = OFF",
+		"SELECT 1 /* unterminated",
+		"SELECT 'unterminated",
+		"ATTACH 'x.db' AS x",
+		"(SELECT 1)",
+	}
+	for _, query := range rejected {
+		if err := checkSingleSelect(query); err == nil {
+			t.Fatalf(`expected to reject "%s"`, query)
+		}
+	}
+}
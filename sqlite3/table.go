@@ -0,0 +1,126 @@
+package sqlite3
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// GetTable queries the rows selected by the target over the time range for
+// display in a Grafana table panel.  The target is interpreted as for
+// GetTimeSeries, but the rows are returned as is, rather than split into
+// series by tag.  The first column holds the time in epoch millis.
+func (seriesMan *sqliteTimeSeriesManager) GetTable(ctx context.Context, target string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *Table) error {
+	if query, ok := rawSQL(target); ok {
+		return seriesMan.getRawTable(ctx, query, fromTo, opts, dest)
+	}
+	// tables list rows as is, ignoring options for series such as downsampling
+	tq, err := seriesMan.parseTarget(target, opts)
+	if err != nil {
+		return err
+	}
+
+	fromTime, toTime, err := seriesMan.formatUserRangeForQuery(fromTo)
+	if err != nil {
+		return errors.Wrap(err, "table")
+	}
+
+	timeReader, err := seriesMan.getTimeToMillis(seriesMan.table, seriesMan.timeColumn)
+	if err != nil {
+		return errors.Wrap(err, "table")
+	}
+
+	query, filterArgs, err := seriesMan.buildQuery(tq, opts)
+	if err != nil {
+		return errors.Wrap(err, "build table query")
+	}
+	sugar.Debugw("table query",
+		"query", query,
+		"from", fromTime,
+		"to", toTime,
+		"filters", filterArgs)
+
+	rows, err := seriesMan.db.QueryContext(ctx, query, append([]interface{}{fromTime, toTime}, filterArgs...)...)
+	if err != nil {
+		return errors.Wrap(err, "bad query for table")
+	}
+	defer rows.Close()
+
+	columnNames := append(append([]string{seriesMan.timeColumn}, tq.valueNames...), tq.tags...)
+	result := Table{Rows: [][]interface{}{}}
+	var values []interface{}
+	rowCount := 0
+	for rows.Next() {
+		rowCount++
+		if err := seriesMan.checkRowCount(rowCount); err != nil {
+			return err
+		}
+		if values == nil {
+			values, err = getScanDest(rows)
+			if err != nil {
+				return err
+			}
+			result.Columns = tableColumns(columnNames, values)
+			// scan into interfaces to read NULL values, too
+			for i := range values {
+				values[i] = new(interface{})
+			}
+		}
+
+		if err := rows.Scan(values...); err != nil {
+			return errors.Errorf("Cannot scan row: %v", err)
+		}
+
+		timeMillis, err := timeReader(scanPointer(values[0]))
+		if err != nil {
+			return err
+		}
+		row := make([]interface{}, len(values))
+		row[0] = timeMillis
+		for i, v := range values[1:] {
+			if p := scanPointer(v); p != nil {
+				row[i+1] = reflect.ValueOf(p).Elem().Interface()
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read table rows")
+	}
+
+	if result.Columns == nil {
+		// no rows to infer the value types from, fall back to the schema
+		result.Columns = make([]TableColumn, len(columnNames))
+		for i, name := range columnNames {
+			result.Columns[i] = TableColumn{
+				Text: name,
+				Type: sql2grafanaType(seriesMan.getColumnType(seriesMan.table, name)),
+			}
+		}
+		result.Columns[0].Type = "time"
+	}
+	*dest = result
+	sugar.Debugw("table completed", "#rows", len(result.Rows))
+	return nil
+}
+
+// Describe table query result columns for Grafana using the types of the
+// scan destinations.  The first column is always time.
+func tableColumns(names []string, values []interface{}) []TableColumn {
+	columns := make([]TableColumn, len(names))
+	for i, name := range names {
+		columns[i].Text = name
+		if i == 0 {
+			columns[i].Type = "time"
+			continue
+		}
+		switch values[i].(type) {
+		case *int64, *float64:
+			columns[i].Type = "number"
+		default:
+			columns[i].Type = "string"
+		}
+	}
+	return columns
+}
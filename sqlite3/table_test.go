@@ -0,0 +1,93 @@
+package sqlite3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func Test_GetTable(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var table Table
+	fromTo := QueryRange{From: "0", To: "10"}
+	if err := tsm.GetTable(context.Background(), "x tag", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected error querying table "%+v"`, err)
+	}
+	expectedColumns := []TableColumn{
+		{Text: "ts", Type: "time"},
+		{Text: "x", Type: "number"},
+		{Text: "tag", Type: "string"},
+	}
+	if !reflect.DeepEqual(expectedColumns, table.Columns) {
+		t.Fatalf(`Expected table columns "%+v", got "%+v"`, expectedColumns, table.Columns)
+	}
+	expectedRows := [][]interface{}{
+		{int64(1000), int64(100), "a"},
+		{int64(2000), int64(200), "b"},
+		{int64(3000), int64(300), "a"},
+		{int64(4000), int64(400), "b"},
+	}
+	if !reflect.DeepEqual(expectedRows, table.Rows) {
+		t.Fatalf(`Expected table rows "%+v", got "%+v"`, expectedRows, table.Rows)
+	}
+}
+
+func Test_GetTableIntervalized(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var table Table
+	fromTo := QueryRange{From: "0", To: "10"}
+	if err := tsm.GetTable(context.Background(), "count(x) t(2*(?/2))", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected error querying table "%+v"`, err)
+	}
+	expectedRows := [][]interface{}{
+		{int64(0), int64(1)},
+		{int64(2000), int64(2)},
+		{int64(4000), int64(1)},
+	}
+	if !reflect.DeepEqual(expectedRows, table.Rows) {
+		t.Fatalf(`Expected table rows "%+v", got "%+v"`, expectedRows, table.Rows)
+	}
+}
+
+func Test_GetTableMultipleValues(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var table Table
+	fromTo := QueryRange{From: "0", To: "10"}
+	if err := tsm.GetTable(context.Background(), "x, f tag", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected error querying table "%+v"`, err)
+	}
+	expectedColumns := []TableColumn{
+		{Text: "ts", Type: "time"},
+		{Text: "x", Type: "number"},
+		{Text: "f", Type: "number"},
+		{Text: "tag", Type: "string"},
+	}
+	if !reflect.DeepEqual(expectedColumns, table.Columns) {
+		t.Fatalf(`Expected table columns "%+v", got "%+v"`, expectedColumns, table.Columns)
+	}
+	if len(table.Rows) != 4 || !reflect.DeepEqual(table.Rows[0], []interface{}{int64(1000), int64(100), 1., "a"}) {
+		t.Fatalf(`Unexpected table rows "%+v"`, table.Rows)
+	}
+}
+
+func Test_GetTableEmpty(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var table Table
+	fromTo := QueryRange{From: "100", To: "200"}
+	if err := tsm.GetTable(context.Background(), "x", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected error querying table "%+v"`, err)
+	}
+	expectedColumns := []TableColumn{
+		{Text: "ts", Type: "time"},
+		{Text: "x", Type: "number"},
+	}
+	if !reflect.DeepEqual(expectedColumns, table.Columns) || len(table.Rows) != 0 {
+		t.Fatalf(`Expected empty table with columns "%+v", got "%+v"`, expectedColumns, table)
+	}
+}
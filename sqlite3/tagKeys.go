@@ -1,16 +1,35 @@
 package sqlite3
 
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 // GetTagKeys returns the column names and and underlying types available
-// to label timeseries observations.
-func (tsm *sqliteTimeSeriesManager) GetTagKeys(target string, dest *[]TagKey) error {
-	valueColumn, keyColumns := tsm.target2tokens(target)
+// to label timeseries observations, leaving out the time column and, unless
+// the target is empty or names the table, the columns the target names.
+func (tsm *sqliteTimeSeriesManager) GetTagKeys(ctx context.Context, target string, dest *[]TagKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	exclude := []string{tsm.timeColumn}
+	if target = strings.TrimSpace(target); target != "" && !strings.EqualFold(target, tsm.table) {
+		tq, err := tsm.parseTarget(target, nil)
+		if err != nil {
+			return err
+		}
+		exclude = append(exclude, tq.columns...)
+	}
 	if err := tsm.getSchema(tsm.table, dest); err != nil {
 		return err
 	}
 	// remove the specified columns from the result
-	for _, col := range append(keyColumns, tsm.timeColumn, valueColumn) {
+	for _, col := range exclude {
 		for idx, i := range *dest {
-			if col == i.Text {
+			if strings.EqualFold(col, i.Text) {
 				n1 := len(*dest) - 1
 				(*dest)[idx] = (*dest)[n1]
 				*dest = (*dest)[0:n1]
@@ -25,3 +44,44 @@ func (tsm *sqliteTimeSeriesManager) GetTagKeys(target string, dest *[]TagKey) er
 	}
 	return nil
 }
+
+// GetTagValues returns the distinct, non-null values stored in the key column,
+// up to limit values, or all of them if limit is not positive.
+func (tsm *sqliteTimeSeriesManager) GetTagValues(ctx context.Context, tableName string, key string, limit int, dest *[]string) error {
+	var schema []TagKey
+	if err := tsm.getSchema(tableName, &schema); err != nil {
+		return err
+	}
+	column := findColumn(schema, key)
+	if column == "" {
+		return errors.Errorf(`unknown tag key "%s" in table %s`, key, tableName)
+	}
+
+	quotedColumn := quoteIdent(column)
+	query := fmt.Sprintf(
+		"SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL ORDER BY %s",
+		quotedColumn, quoteIdent(tableName), quotedColumn, quotedColumn)
+	if limit > 0 {
+		query = fmt.Sprintf("%s LIMIT %d", query, limit)
+	}
+	sugar.Debugw("tag values", "query", query)
+	rows, err := tsm.db.QueryContext(ctx, query)
+	if err != nil {
+		return errors.Wrap(err, "bad query for tag values")
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return errors.Errorf("Cannot scan tag value: %v", err)
+		}
+		values = append(values, value)
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read tag values")
+	}
+	*dest = values
+	return nil
+}
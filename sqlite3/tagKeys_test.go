@@ -1,6 +1,7 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"reflect"
 	"testing"
@@ -12,7 +13,7 @@ func Test_GetTagKeysFailsOnInvalidTableName(t *testing.T) {
 	db, _ := sql.Open("sqlite3", ":memory:")
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "t"}
 	var keys []TagKey
-	if err := tsm.GetTagKeys("nonExistentTable", &keys); err == nil {
+	if err := tsm.GetTagKeys(context.Background(), "nonExistentTable", &keys); err == nil {
 		t.Fatalf("Did not fail to get keys from non-existant table: %v", keys)
 	}
 }
@@ -22,13 +23,21 @@ func Test_GetTagKeys(t *testing.T) {
 	db.Exec("CREATE TABLE tsTab (x INT, tag TEXT, t INT)")
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "t"}
 	var keys []TagKey
-	if err := tsm.GetTagKeys("tsTab t x", &keys); err != nil {
+	if err := tsm.GetTagKeys(context.Background(), "avg(x) i(1m)", &keys); err != nil {
 		t.Fatalf("Failed to query keys: %v", err)
 	}
 	expectedKey := TagKey{Type: "string", Text: "tag"}
 	if keys == nil || len(keys) != 1 || keys[0] != expectedKey {
 		t.Fatalf(`Failed to infer keys expected "[%v]", got "%v"`, expectedKey, keys)
 	}
+
+	keys = nil
+	if err := tsm.GetTagKeys(context.Background(), "x TAG", &keys); err != nil || len(keys) != 0 {
+		t.Fatalf(`Expected no keys left after value and tag, got "%v", "%v"`, keys, err)
+	}
+	if err := tsm.GetTagKeys(context.Background(), "x nonsense(", &keys); err == nil {
+		t.Fatalf("Expected failure getting keys for a malformed target")
+	}
 }
 
 func Test_GetTagKeysRaw(t *testing.T) {
@@ -36,7 +45,7 @@ func Test_GetTagKeysRaw(t *testing.T) {
 	db.Exec("CREATE TABLE tsTab (x INT, tag TEXT, t INT)")
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "t"}
 	var keys []TagKey
-	if err := tsm.GetTagKeys("tsTab", &keys); err != nil {
+	if err := tsm.GetTagKeys(context.Background(), "tsTab", &keys); err != nil {
 		t.Fatalf("Failed to query keys: %v", err)
 	}
 	expectedKeys := []TagKey{
@@ -53,3 +62,28 @@ func Test_DetectUnknownColumnType(t *testing.T) {
 		t.Fatalf(`sql2grafana must return "???" for unknown, got "%s"`, colType)
 	}
 }
+
+func Test_GetTagValues(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var values []string
+	if err := tsm.GetTagValues(context.Background(), "tsTab", "TAG", 0, &values); err != nil {
+		t.Fatalf("Failed to query tag values: %v", err)
+	}
+	expected := []string{"a", "b"}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf(`expected tag values "%+v", but got "%+v"`, expected, values)
+	}
+
+	if err := tsm.GetTagValues(context.Background(), "tsTab", "x", 1, &values); err != nil {
+		t.Fatalf("Failed to query limited tag values: %v", err)
+	}
+	expected = []string{"100"}
+	if !reflect.DeepEqual(expected, values) {
+		t.Fatalf(`expected limited tag values "%+v", but got "%+v"`, expected, values)
+	}
+
+	if err := tsm.GetTagValues(context.Background(), "tsTab", "x; DROP TABLE tsTab", 0, &values); err == nil {
+		t.Fatalf("Expected failure querying values of unknown column")
+	}
+}
@@ -0,0 +1,558 @@
+package sqlite3
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
+)
+
+// A target is parsed by the grammar
+//
+//	target  := expr (',' expr)* option*
+//	option  := column | 't(' expr ')' | 'i(' duration ')' | 'auto'
+//	         | 'downsample(' name ')' | 'fill(' (name | number) ')'
+//	         | 'nulls(' ('keep' | 'skip' | 'zero') ')'
+//	         | 'rate()' | 'delta()' | 'cumsum()' | 'movingavg(' number ')'
+//	expr    := term (('+' | '-') term)*
+//	term    := factor (('*' | '/' | '%') factor)*
+//	factor  := '-' factor | number | string | column | '?' | '(' expr ')'
+//	         | function '(' [['DISTINCT'] expr (',' expr)* | '*'] ')'
+//
+// where columns must name columns of the table, functions must be listed in
+// targetFunctions, and '?' stands for the time column inside t().  Columns
+// may be double-quoted, and strings are single-quoted, as in SQL.
+
+// TargetError describes a target that cannot be parsed, locating the problem
+// by its byte offset in the target.
+type TargetError struct {
+	Target string
+	Pos    int
+	Msg    string
+}
+
+func (e *TargetError) Error() string {
+	return fmt.Sprintf(`malformed target "%s" at position %d: %s`, e.Target, e.Pos, e.Msg)
+}
+
+// SQLite functions allowed in target expressions, mapped to whether they
+// aggregate rows.
+var targetFunctions = map[string]bool{
+	"avg":          true,
+	"count":        true,
+	"group_concat": true,
+	"max":          true,
+	"min":          true,
+	"sum":          true,
+	"total":        true,
+	"abs":          false,
+	"coalesce":     false,
+	"date":         false,
+	"datetime":     false,
+	"ifnull":       false,
+	"julianday":    false,
+	"length":       false,
+	"lower":        false,
+	"nullif":       false,
+	"round":        false,
+	"strftime":     false,
+	"substr":       false,
+	"time":         false,
+	"trim":         false,
+	"upper":        false,
+}
+
+func init() {
+	// allow the aggregates the driver adds
+	for name := range customAggregates {
+		targetFunctions[name] = true
+	}
+}
+
+// targetQuery holds a target parsed and checked against the table schema,
+// with its expressions rendered as SQL.
+type targetQuery struct {
+	// valueNames are the value expressions as entered by the user, naming
+	// the series.
+	valueNames []string
+	valuesSQL  []string
+	// tags are the tag column names as declared in the schema.
+	tags    []string
+	tagsSQL []string
+	// timeSQL transforms the time column for intervalization, or is empty.
+	timeSQL string
+	// interval is the length of i() and auto intervals, or zero.
+	interval   time.Duration
+	downsample string
+	// fill names the mode filling missing intervals, or is empty.
+	fill string
+	// nulls is "skip" or "zero" to drop or zero NULL values, or empty to
+	// keep them.
+	nulls string
+	// transforms apply to each series in order.
+	transforms []seriesTransform
+	// filters restrict the rows, along with the ad hoc filters.
+	filters []QueryFilter
+	// descending orders rows latest first, and limit bounds the rows read,
+	// if positive.
+	descending bool
+	limit      int
+	// columns are the columns named by the target, as declared in the schema.
+	columns []string
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	// end is the offset following the token in the target.
+	end int
+}
+
+var numberPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]*)?([eE][+-]?[0-9]+)?$`)
+
+// Split the target into tokens.  Numbers include any trailing letters, as
+// in durations such as "10s", to be checked by the parser.
+func lexTarget(target string) ([]token, error) {
+	var tokens []token
+	runes := []rune(target)
+	offsets := make([]int, len(runes)+1)
+	n := 0
+	for i, r := range runes {
+		offsets[i] = n
+		n += len(string(r))
+	}
+	offsets[len(runes)] = n
+
+	isIdentRune := func(r rune) bool {
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	fail := func(i int, msg string) error {
+		return &TargetError{Target: target, Pos: offsets[i], Msg: msg}
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '_' || unicode.IsLetter(r):
+			for i < len(runes) && isIdentRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[start:i])})
+		case unicode.IsDigit(r):
+			for i < len(runes) && (isIdentRune(runes[i]) || runes[i] == '.' ||
+				((runes[i] == '+' || runes[i] == '-') && (runes[i-1] == 'e' || runes[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[start:i])})
+		case r == '\'' || r == '"':
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fail(start, fmt.Sprintf("unterminated %c", r))
+				}
+				if runes[i] == r {
+					if i+1 < len(runes) && runes[i+1] == r {
+						i++
+					} else {
+						break
+					}
+				}
+				text.WriteRune(runes[i])
+			}
+			i++
+			kind := tokString
+			if r == '"' {
+				kind = tokQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: text.String()})
+		case strings.ContainsRune("+-*/%(),?.@", r):
+			i++
+			tokens = append(tokens, token{kind: tokPunct, text: string(r)})
+		default:
+			return nil, fail(i, fmt.Sprintf(`unexpected character "%c"`, r))
+		}
+		tokens[len(tokens)-1].pos = offsets[start]
+		tokens[len(tokens)-1].end = offsets[i]
+	}
+	return append(tokens, token{kind: tokEOF, pos: n, end: n}), nil
+}
+
+// targetParser renders tokens of a target into SQL, checking column names
+// against the table schema.
+type targetParser struct {
+	target     string
+	tokens     []token
+	next       int
+	schema     []TagKey
+	timeColumn string
+	// inTime is set while parsing t(), allowing '?' for the time column.
+	inTime bool
+	// columns collects the columns resolved, as declared in the schema.
+	columns []string
+}
+
+func (p *targetParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *targetParser) advance() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokEOF {
+		p.next++
+	}
+	return tok
+}
+
+func (p *targetParser) isPunct(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokPunct && tok.text == text
+}
+
+func (p *targetParser) fail(tok token, format string, args ...interface{}) error {
+	return &TargetError{Target: p.target, Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *targetParser) expect(text string) error {
+	if !p.isPunct(text) {
+		return p.fail(p.peek(), `expected "%s"`, text)
+	}
+	p.advance()
+	return nil
+}
+
+// Describe a token for error messages.
+func describe(tok token) string {
+	if tok.kind == tokEOF {
+		return "end of target"
+	}
+	return fmt.Sprintf(`"%s"`, tok.text)
+}
+
+// Resolve a column name against the schema, returning it quoted for SQL
+// along with its declared name.
+func (p *targetParser) column(tok token) (string, string, error) {
+	name := findColumn(p.schema, tok.text)
+	if name == "" {
+		return "", "", p.fail(tok, `unknown column "%s"`, tok.text)
+	}
+	p.columns = append(p.columns, name)
+	return quoteIdent(name), name, nil
+}
+
+func (p *targetParser) parseExpr() (string, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return "", err
+	}
+	for p.isPunct("+") || p.isPunct("-") {
+		op := p.advance().text
+		right, err := p.parseTerm()
+		if err != nil {
+			return "", err
+		}
+		left = left + op + right
+	}
+	return left, nil
+}
+
+func (p *targetParser) parseTerm() (string, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return "", err
+	}
+	for p.isPunct("*") || p.isPunct("/") || p.isPunct("%") {
+		op := p.advance().text
+		right, err := p.parseFactor()
+		if err != nil {
+			return "", err
+		}
+		left = left + op + right
+	}
+	return left, nil
+}
+
+func (p *targetParser) parseFactor() (string, error) {
+	tok := p.advance()
+	switch tok.kind {
+	case tokNumber:
+		if !numberPattern.MatchString(tok.text) {
+			return "", p.fail(tok, `malformed number "%s"`, tok.text)
+		}
+		return tok.text, nil
+	case tokString:
+		return quoteString(tok.text), nil
+	case tokQuotedIdent:
+		sql, _, err := p.column(tok)
+		return sql, err
+	case tokIdent:
+		if p.isPunct("(") {
+			return p.parseCall(tok)
+		}
+		sql, _, err := p.column(tok)
+		return sql, err
+	case tokPunct:
+		switch tok.text {
+		case "-":
+			operand, err := p.parseFactor()
+			if err != nil {
+				return "", err
+			}
+			// parenthesize to avoid rendering "--", a SQL comment
+			return "(-" + operand + ")", nil
+		case "(":
+			inner, err := p.parseExpr()
+			if err != nil {
+				return "", err
+			}
+			if err := p.expect(")"); err != nil {
+				return "", err
+			}
+			return "(" + inner + ")", nil
+		case "?":
+			if !p.inTime {
+				return "", p.fail(tok, `"?" stands for the time column only within t()`)
+			}
+			return quoteIdent(p.timeColumn), nil
+		}
+	}
+	return "", p.fail(tok, "expected a column, number, string, or function, but found %s", describe(tok))
+}
+
+func (p *targetParser) parseCall(name token) (string, error) {
+	function := strings.ToLower(name.text)
+	aggregate, ok := targetFunctions[function]
+	if !ok {
+		return "", p.fail(name, `unknown function "%s"`, name.text)
+	}
+	p.advance() // (
+
+	var args []string
+	distinct := ""
+	if p.isPunct("*") && function == "count" {
+		p.advance()
+		args = append(args, "*")
+	} else if !p.isPunct(")") {
+		if tok := p.peek(); aggregate && tok.kind == tokIdent && strings.EqualFold(tok.text, "distinct") &&
+			!(p.tokens[p.next+1].kind == tokPunct && strings.Contains(",()", p.tokens[p.next+1].text)) {
+			p.advance()
+			distinct = "DISTINCT "
+		}
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return "", err
+			}
+			args = append(args, arg)
+			if !p.isPunct(",") {
+				break
+			}
+			p.advance()
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return "", err
+	}
+	return function + "(" + distinct + strings.Join(args, ", ") + ")", nil
+}
+
+// Parse the argument of an option function up to the closing parenthesis
+// as a single token of the expected kind.
+func (p *targetParser) parseOptionArg(option token, kind tokenKind, what string) (token, error) {
+	arg := p.advance()
+	if arg.kind != kind {
+		return arg, p.fail(arg, "%s() expects %s, but found %s", option.text, what, describe(arg))
+	}
+	return arg, p.expect(")")
+}
+
+// Parse the target into a query on the table with the given schema and time
+// column.  The time bucket function builds intervalization expressions for
+// i() and auto options, from a duration string or the empty string for auto,
+// along with the interval length.
+func parseTargetQuery(target string, schema []TagKey, timeColumn string,
+	timeBucket func(duration string) (string, time.Duration, error)) (*targetQuery, error) {
+	tokens, err := lexTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	p := targetParser{target: target, tokens: tokens, schema: schema, timeColumn: timeColumn}
+
+	var tq targetQuery
+	first := p.peek()
+	if first.kind == tokEOF {
+		return nil, p.fail(first, "expected a value expression")
+	}
+	for {
+		start := p.peek()
+		sql, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		tq.valueNames = append(tq.valueNames, target[start.pos:p.tokens[p.next-1].end])
+		tq.valuesSQL = append(tq.valuesSQL, sql)
+		if !p.isPunct(",") {
+			break
+		}
+		p.advance()
+	}
+
+	var timeOption, fillOption *token
+	setTime := func(option token, sql string) error {
+		if timeOption != nil {
+			return p.fail(option, `"%s" conflicts with "%s" at position %d`,
+				option.text, timeOption.text, timeOption.pos)
+		}
+		timeOption = &option
+		tq.timeSQL = sql
+		return nil
+	}
+
+	for p.peek().kind != tokEOF {
+		option := p.advance()
+		switch {
+		case option.kind == tokIdent && p.isPunct("("):
+			p.advance()
+			switch strings.ToLower(option.text) {
+			case "t":
+				p.inTime = true
+				sql, err := p.parseExpr()
+				p.inTime = false
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				if err := setTime(option, sql); err != nil {
+					return nil, err
+				}
+			case "i":
+				arg, err := p.parseOptionArg(option, tokNumber, "a duration")
+				if err != nil {
+					return nil, err
+				}
+				if _, err := timecodex.ParseDuration(arg.text); err != nil {
+					return nil, p.fail(arg, "%v", err)
+				}
+				sql, interval, err := timeBucket(arg.text)
+				if err != nil {
+					return nil, p.fail(option, "%v", err)
+				}
+				if err := setTime(option, sql); err != nil {
+					return nil, err
+				}
+				tq.interval = interval
+			case "downsample":
+				arg, err := p.parseOptionArg(option, tokIdent, "an algorithm name")
+				if err != nil {
+					return nil, err
+				}
+				if _, err := getDownsampler(arg.text); err != nil {
+					return nil, p.fail(arg, "%v", err)
+				}
+				tq.downsample = arg.text
+			case "fill":
+				arg := p.advance()
+				if arg.kind != tokIdent && arg.kind != tokNumber {
+					return nil, p.fail(arg, "fill() expects null, previous, linear, or a number, but found %s",
+						describe(arg))
+				}
+				if err := checkFill(arg.text); err != nil {
+					return nil, p.fail(arg, "%v", err)
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				fillOption = &option
+				tq.fill = strings.ToLower(arg.text)
+			case "nulls":
+				arg, err := p.parseOptionArg(option, tokIdent, "keep, skip, or zero")
+				if err != nil {
+					return nil, err
+				}
+				switch mode := strings.ToLower(arg.text); mode {
+				case "keep":
+					tq.nulls = ""
+				case "skip", "zero":
+					tq.nulls = mode
+				default:
+					return nil, p.fail(arg, `unknown nulls mode "%s", expected keep, skip, or zero`, arg.text)
+				}
+			default:
+				name := strings.ToLower(option.text)
+				if _, ok := seriesTransforms[name]; !ok {
+					return nil, p.fail(option, `unknown option "%s"`, option.text)
+				}
+				arg := p.peek()
+				var argText string
+				if !p.isPunct(")") {
+					p.advance()
+					if arg.kind != tokNumber {
+						return nil, p.fail(arg, "%s() expects a number, but found %s", option.text, describe(arg))
+					}
+					argText = arg.text
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				st, err := newSeriesTransform(name, argText)
+				if err != nil {
+					return nil, p.fail(arg, "%v", err)
+				}
+				tq.transforms = append(tq.transforms, st)
+			}
+		case option.kind == tokIdent && strings.EqualFold(option.text, "auto"):
+			sql, interval, err := timeBucket("")
+			if err != nil {
+				return nil, p.fail(option, "%v", err)
+			}
+			if err := setTime(option, sql); err != nil {
+				return nil, err
+			}
+			tq.interval = interval
+		case option.kind == tokIdent || option.kind == tokQuotedIdent:
+			sql, name, err := p.column(option)
+			if err != nil {
+				return nil, err
+			}
+			tq.tags = append(tq.tags, name)
+			tq.tagsSQL = append(tq.tagsSQL, sql)
+		default:
+			return nil, p.fail(option, "expected a tag column or option, but found %s", describe(option))
+		}
+	}
+	if fillOption != nil && timeOption == nil {
+		return nil, p.fail(*fillOption, "fill() requires intervals from t(), i(), or auto")
+	}
+	tq.columns = p.columns
+	return &tq, nil
+}
+
+// Quote an identifier for SQLite.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// Quote a string literal for SQLite.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,172 @@
+package sqlite3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var targetTestSchema = []TagKey{
+	{"INT", "x"},
+	{"TEXT", "tag"},
+	{"INT", "ts"},
+	{"REAL", "my col"},
+}
+
+func noTimeBucket(duration string) (string, time.Duration, error) {
+	return "bucket(" + duration + ")", 0, nil
+}
+
+func Test_parseTargetQuery(t *testing.T) {
+	cases := []struct {
+		target string
+		query  targetQuery
+	}{
+		{"x", targetQuery{valueNames: []string{"x"}, valuesSQL: []string{`"x"`}, columns: []string{"x"}}},
+		{"X Tag", targetQuery{valueNames: []string{"X"}, valuesSQL: []string{`"x"`}, tags: []string{"tag"}, tagsSQL: []string{`"tag"`},
+			columns: []string{"x", "tag"}}},
+		{`avg("my col") * 2 - -1 "tag"`, targetQuery{
+			valueNames: []string{`avg("my col") * 2 - -1`},
+			valuesSQL:  []string{`avg("my col")*2-(-1)`},
+			tags:       []string{"tag"},
+			tagsSQL:    []string{`"tag"`},
+			columns:    []string{"my col", "tag"},
+		}},
+		{"count(*) t(3600*(?/3600))", targetQuery{
+			valueNames: []string{"count(*)"},
+			valuesSQL:  []string{"count(*)"},
+			timeSQL:    `3600*("ts"/3600)`,
+		}},
+		{"COUNT(DISTINCT tag) i(1h) downsample(minmax)", targetQuery{
+			valueNames: []string{"COUNT(DISTINCT tag)"},
+			valuesSQL:  []string{`count(DISTINCT "tag")`},
+			timeSQL:    "bucket(1h)",
+			downsample: "minmax",
+			columns:    []string{"tag"},
+		}},
+		{"round(x / 3.5e2, 2) auto", targetQuery{
+			valueNames: []string{"round(x / 3.5e2, 2)"},
+			valuesSQL:  []string{`round("x"/3.5e2, 2)`},
+			timeSQL:    "bucket()",
+			columns:    []string{"x"},
+		}},
+		{"min(x), avg(x),max(x) tag i(1h)", targetQuery{
+			valueNames: []string{"min(x)", "avg(x)", "max(x)"},
+			valuesSQL:  []string{`min("x")`, `avg("x")`, `max("x")`},
+			tags:       []string{"tag"},
+			tagsSQL:    []string{`"tag"`},
+			timeSQL:    "bucket(1h)",
+			columns:    []string{"x", "x", "x", "tag"},
+		}},
+		{"x i(1h) fill(0)", targetQuery{
+			valueNames: []string{"x"},
+			valuesSQL:  []string{`"x"`},
+			timeSQL:    "bucket(1h)",
+			fill:       "0",
+			columns:    []string{"x"},
+		}},
+		{"count(x) tag auto fill(Previous)", targetQuery{
+			valueNames: []string{"count(x)"},
+			valuesSQL:  []string{`count("x")`},
+			tags:       []string{"tag"},
+			tagsSQL:    []string{`"tag"`},
+			timeSQL:    "bucket()",
+			fill:       "previous",
+			columns:    []string{"x", "tag"},
+		}},
+		{"x tag nulls(SKIP)", targetQuery{
+			valueNames: []string{"x"},
+			valuesSQL:  []string{`"x"`},
+			tags:       []string{"tag"},
+			tagsSQL:    []string{`"tag"`},
+			nulls:      "skip",
+			columns:    []string{"x", "tag"},
+		}},
+		{"x rate() movingavg(5)", targetQuery{
+			valueNames: []string{"x"},
+			valuesSQL:  []string{`"x"`},
+			transforms: []seriesTransform{{name: "rate"}, {name: "movingavg", window: 5}},
+			columns:    []string{"x"},
+		}},
+		{"coalesce(tag, 'it''s')", targetQuery{
+			valueNames: []string{"coalesce(tag, 'it''s')"},
+			valuesSQL:  []string{`coalesce("tag", 'it''s')`},
+			columns:    []string{"tag"},
+		}},
+	}
+	for _, c := range cases {
+		tq, err := parseTargetQuery(c.target, targetTestSchema, "ts", noTimeBucket)
+		if err != nil {
+			t.Fatalf(`Unexpected error parsing "%s": %v`, c.target, err)
+		}
+		if !reflect.DeepEqual(c.query, *tq) {
+			t.Fatalf(`Expected "%s" to parse as %+v, got %+v`, c.target, c.query, *tq)
+		}
+	}
+}
+
+func Test_parseTargetQueryErrors(t *testing.T) {
+	cases := []struct {
+		target string
+		pos    int
+	}{
+		{"", 0},
+		{"y", 0},
+		{"x; DROP TABLE tsTab", 1},
+		{"x tag--", 5},
+		{"x) FROM sqlite_master --", 1},
+		{"load_extension('evil')", 0},
+		{"x unknown(1)", 2},
+		{"x t(?", 5},
+		{"?", 0},
+		{"x i(soon)", 4},
+		{"x i(1h) auto", 8},
+		{"x downsample(fancy)", 13},
+		{"'unterminated", 0},
+		{"x 12abc", 2},
+		{"sum(x", 5},
+		{`x "nope"`, 2},
+		{"x, , tag", 3},
+		{"x,", 2},
+		{"x fill(null)", 2},
+		{"x i(1h) fill(zero)", 13},
+		{"x i(1h) fill('a')", 13},
+		{"x nulls(drop)", 8},
+		{"x rate(1)", 7},
+		{"x movingavg(0)", 12},
+		{"x movingavg()", 12},
+		{"x movingavg(tag)", 12},
+	}
+	for _, c := range cases {
+		_, err := parseTargetQuery(c.target, targetTestSchema, "ts", noTimeBucket)
+		targetErr, ok := err.(*TargetError)
+		if !ok {
+			t.Fatalf(`Expected a target error parsing "%s", got %v`, c.target, err)
+		}
+		if targetErr.Pos != c.pos {
+			t.Fatalf(`Expected error parsing "%s" at position %d, got %v`, c.target, c.pos, err)
+		}
+	}
+}
+
+func Test_GetTimeSeriesRejectsInjection(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var ts map[string][]DataPoint
+	fromTo := QueryRange{From: "0", To: "10"}
+	err := tsm.GetTimeSeries(context.Background(), "x FROM tsTab; DROP TABLE tsTab; --", &fromTo, nil, &ts)
+	if _, ok := err.(*TargetError); !ok {
+		t.Fatalf(`Expected target error, got "%+v"`, err)
+	}
+
+	var schema []TagKey
+	if err := tsm.getSchema("tsTab); DROP TABLE tsTab; --", &schema); err == nil {
+		t.Fatalf("Expected failure reading schema of malformed table name")
+	}
+	if err := tsm.getSchema("tsTab", &schema); err != nil {
+		t.Fatalf("Expected table to remain, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package sqlite3
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TargetDataError describes target data that cannot be queried, naming the
+// field, e.g. "values[1]".
+type TargetDataError struct {
+	Field string
+	Err   error
+}
+
+func (e *TargetDataError) Error() string {
+	return fmt.Sprintf("bad target data %s: %v", e.Field, e.Err)
+}
+
+// Check whether the target data holds a structured query, rather than only
+// naming the table and time column.
+func (data *TargetData) isStructured() bool {
+	return data != nil && len(data.Values) > 0
+}
+
+// Parse structured target data into a query on the table with the given
+// schema and time column, as parseTargetQuery parses target text.  The
+// bucket, "auto" or a duration, is intervalized by the time bucket function
+// as for the auto and i() options.
+func parseTargetData(data *TargetData, schema []TagKey, timeColumn string,
+	timeBucket func(duration string) (string, time.Duration, error)) (*targetQuery, error) {
+	fail := func(field string, err error) error {
+		return &TargetDataError{Field: field, Err: err}
+	}
+	failf := func(field string, format string, args ...interface{}) error {
+		return fail(field, errors.Errorf(format, args...))
+	}
+
+	var tq targetQuery
+	for i, value := range data.Values {
+		field := fmt.Sprintf("values[%d]", i)
+		tokens, err := lexTarget(value)
+		if err != nil {
+			return nil, fail(field, err)
+		}
+		p := targetParser{target: value, tokens: tokens, schema: schema, timeColumn: timeColumn}
+		if tok := p.peek(); tok.kind == tokEOF {
+			return nil, fail(field, p.fail(tok, "expected a value expression"))
+		}
+		sql, err := p.parseExpr()
+		if err != nil {
+			return nil, fail(field, err)
+		}
+		if tok := p.peek(); tok.kind != tokEOF {
+			return nil, fail(field, p.fail(tok, "expected the end of the value expression, but found %s",
+				describe(tok)))
+		}
+		tq.valueNames = append(tq.valueNames, strings.TrimSpace(value))
+		tq.valuesSQL = append(tq.valuesSQL, sql)
+		tq.columns = append(tq.columns, p.columns...)
+	}
+
+	for i, tag := range data.Tags {
+		name := findColumn(schema, tag)
+		if name == "" {
+			return nil, failf(fmt.Sprintf("tags[%d]", i), `unknown column "%s"`, tag)
+		}
+		tq.tags = append(tq.tags, name)
+		tq.tagsSQL = append(tq.tagsSQL, quoteIdent(name))
+		tq.columns = append(tq.columns, name)
+	}
+
+	if data.Bucket != "" {
+		duration := data.Bucket
+		if strings.EqualFold(duration, "auto") {
+			duration = ""
+		}
+		sql, interval, err := timeBucket(duration)
+		if err != nil {
+			return nil, fail("bucket", err)
+		}
+		tq.timeSQL = sql
+		tq.interval = interval
+	}
+
+	if data.Fill != "" {
+		if data.Bucket == "" {
+			return nil, failf("fill", "fill requires a bucket")
+		}
+		if err := checkFill(data.Fill); err != nil {
+			return nil, fail("fill", err)
+		}
+		tq.fill = strings.ToLower(data.Fill)
+	}
+
+	switch strings.ToLower(data.Order) {
+	case "", "asc":
+	case "desc":
+		tq.descending = true
+	default:
+		return nil, failf("order", `unknown order "%s", expected asc or desc`, data.Order)
+	}
+	if data.Limit < 0 {
+		return nil, failf("limit", "limit %d must not be negative", data.Limit)
+	}
+	tq.limit = data.Limit
+	tq.filters = data.Filters
+	return &tq, nil
+}
@@ -0,0 +1,107 @@
+package sqlite3
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func Test_parseTargetData(t *testing.T) {
+	data := TargetData{
+		Values:  []string{"avg(x)", ` max("my col") `},
+		Tags:    []string{"TAG"},
+		Bucket:  "1h",
+		Filters: []QueryFilter{{Key: "tag", Operator: "=", Value: "a"}},
+		Fill:    "Null",
+		Order:   "desc",
+		Limit:   10,
+	}
+	tq, err := parseTargetData(&data, targetTestSchema, "ts", noTimeBucket)
+	if err != nil {
+		t.Fatalf(`Unexpected error parsing target data: %v`, err)
+	}
+	expected := targetQuery{
+		valueNames: []string{"avg(x)", `max("my col")`},
+		valuesSQL:  []string{`avg("x")`, `max("my col")`},
+		tags:       []string{"tag"},
+		tagsSQL:    []string{`"tag"`},
+		timeSQL:    "bucket(1h)",
+		fill:       "null",
+		filters:    []QueryFilter{{Key: "tag", Operator: "=", Value: "a"}},
+		descending: true,
+		limit:      10,
+		columns:    []string{"x", "my col", "tag"},
+	}
+	if !reflect.DeepEqual(expected, *tq) {
+		t.Fatalf(`Expected target data to parse as %+v, got %+v`, expected, *tq)
+	}
+}
+
+func Test_parseTargetDataErrors(t *testing.T) {
+	cases := []struct {
+		data  TargetData
+		field string
+	}{
+		{TargetData{Values: []string{"x", "y"}}, "values[1]"},
+		{TargetData{Values: []string{"x tag"}}, "values[0]"},
+		{TargetData{Values: []string{" "}}, "values[0]"},
+		{TargetData{Values: []string{"x"}, Tags: []string{"nope"}}, "tags[0]"},
+		{TargetData{Values: []string{"x"}, Fill: "0"}, "fill"},
+		{TargetData{Values: []string{"x"}, Bucket: "1h", Fill: "zero"}, "fill"},
+		{TargetData{Values: []string{"x"}, Order: "sideways"}, "order"},
+		{TargetData{Values: []string{"x"}, Limit: -1}, "limit"},
+	}
+	for _, c := range cases {
+		_, err := parseTargetData(&c.data, targetTestSchema, "ts", noTimeBucket)
+		dataErr, ok := err.(*TargetDataError)
+		if !ok {
+			t.Fatalf(`Expected a target data error parsing %+v, got %v`, c.data, err)
+		}
+		if dataErr.Field != c.field {
+			t.Fatalf(`Expected error parsing %+v in %s, got %v`, c.data, c.field, err)
+		}
+	}
+}
+
+func Test_GetTimeSeriesTargetData(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "0", To: "10"}
+	opts := TimeSeriesQueryOpts{Data: &TargetData{
+		Values: []string{"x"},
+		Tags:   []string{"tag"},
+		Order:  "desc",
+		Limit:  3,
+	}}
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "", &fromTo, &opts, &ts); err != nil {
+		t.Fatalf(`Unexpected error querying target data "%+v"`, err)
+	}
+	expected := map[string][]DataPoint{
+		"a": {{Time: 3000, Value: 300}},
+		"b": {{Time: 2000, Value: 200}, {Time: 4000, Value: 400}},
+	}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Fatalf("Expected the latest rows in time order %+v, got %+v", expected, ts)
+	}
+
+	opts.Data = &TargetData{Values: []string{"sum(x)"}, Bucket: "2s",
+		Filters: []QueryFilter{{Key: "tag", Operator: "=", Value: "a"}}}
+	if err := tsm.GetTimeSeries(context.Background(), "", &fromTo, &opts, &ts); err != nil {
+		t.Fatalf(`Unexpected error querying target data "%+v"`, err)
+	}
+	expected = map[string][]DataPoint{"sum(x)": {{Time: 0, Value: 100}, {Time: 2000, Value: 300}}}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Fatalf("Expected filtered intervals %+v, got %+v", expected, ts)
+	}
+
+	opts.Data.Filters = []QueryFilter{{Key: "nope", Operator: "=", Value: "a"}}
+	err := tsm.GetTimeSeries(context.Background(), "", &fromTo, &opts, &ts)
+	if dataErr, ok := err.(*TargetDataError); !ok || dataErr.Field != "filters[0]" {
+		t.Fatalf(`Expected an error in filters[0], got %v`, err)
+	}
+
+	if err := tsm.GetTimeSeries(context.Background(), "x", &fromTo, &opts, &ts); err == nil {
+		t.Fatalf("Expected target text and data values to conflict")
+	}
+}
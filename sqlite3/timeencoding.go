@@ -0,0 +1,413 @@
+package sqlite3
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonathanlb/sqlite32grafana/timecodex"
+	"github.com/pkg/errors"
+)
+
+// timeEncoding describes how a column stores times.
+type timeEncoding struct {
+	kind encodingKind
+	// unit of epoch times
+	unit time.Duration
+	// layout of text times in Go time format, or empty for ISO-8601 and
+	// SQLite datetime() text
+	layout string
+}
+
+type encodingKind int
+
+const (
+	// epochEncoding stores numbers of the unit since the epoch.
+	epochEncoding encodingKind = iota
+	// julianEncoding stores fractional days since noon of November 24,
+	// 4714 BC, as returned by julianday().
+	julianEncoding
+	// textEncoding stores text, as returned by datetime() or in the layout.
+	textEncoding
+)
+
+// Epoch units named by the TimeFormats option.
+var epochUnits = map[string]time.Duration{
+	"epoch_s":  time.Second,
+	"epoch_ms": time.Millisecond,
+	"epoch_us": time.Microsecond,
+	"epoch_ns": time.Nanosecond,
+}
+
+// Two times differing in every field, to check that a layout formats them
+// differently.
+var (
+	layoutTime1 = time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	layoutTime2 = time.Date(2011, 12, 13, 10, 21, 32, 0, time.UTC)
+)
+
+// Parse a time format of the TimeFormats option: "epoch" to guess the unit
+// from the column values, "epoch_s", "epoch_ms", "epoch_us", "epoch_ns",
+// "julian", "iso8601", or else a Go time layout.
+func parseTimeFormat(format string) (timeEncoding, error) {
+	switch name := strings.ToLower(format); name {
+	case "epoch":
+		return timeEncoding{kind: epochEncoding}, nil
+	case "julian":
+		return timeEncoding{kind: julianEncoding}, nil
+	case "iso8601":
+		return timeEncoding{kind: textEncoding}, nil
+	default:
+		if unit, ok := epochUnits[name]; ok {
+			return timeEncoding{kind: epochEncoding, unit: unit}, nil
+		}
+	}
+	if layoutTime1.Format(format) == layoutTime2.Format(format) {
+		return timeEncoding{}, errors.Errorf(`unknown time format "%s", expected epoch_s, epoch_ms, epoch_us, `+
+			`epoch_ns, julian, iso8601, or a Go time layout`, format)
+	}
+	return timeEncoding{kind: textEncoding, layout: format}, nil
+}
+
+// Name the encoding as for the TimeFormats option.
+func (encoding timeEncoding) String() string {
+	switch encoding.kind {
+	case epochEncoding:
+		for name, unit := range epochUnits {
+			if unit == encoding.unit {
+				return name
+			}
+		}
+		return "epoch"
+	case julianEncoding:
+		return "julian"
+	default:
+		if encoding.layout != "" {
+			return encoding.layout
+		}
+		return "iso8601"
+	}
+}
+
+// The Julian day of the epoch, and the range of Julian days taken as times,
+// the years 1 through 9999.
+const (
+	julianEpoch   = 2440587.5
+	julianMinimum = 1721425.5
+	julianMaximum = 5373484.5
+	millisPerDay  = 86400000
+)
+
+// The encodings last guessed for each DB, table, and time column, to log
+// guesses as they change.
+var guessedEncodings sync.Map
+
+type guessKey struct {
+	db *sql.DB
+	TableTimeColumn
+}
+
+// Determine the SQLite type affinity of a declared column type, INTEGER,
+// TEXT, BLOB, REAL, or NUMERIC, following the rules at
+// https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func typeAffinity(declaredType string) string {
+	t := strings.ToUpper(declaredType)
+	switch {
+	case strings.Contains(t, "INT"):
+		return "INTEGER"
+	case strings.Contains(t, "CHAR"), strings.Contains(t, "CLOB"), strings.Contains(t, "TEXT"):
+		return "TEXT"
+	case strings.Contains(t, "BLOB"), t == "":
+		return "BLOB"
+	case strings.Contains(t, "REAL"), strings.Contains(t, "FLOA"), strings.Contains(t, "DOUB"):
+		return "REAL"
+	default:
+		return "NUMERIC"
+	}
+}
+
+// Check whether a declared column type names a date or time, such as DATE,
+// DATETIME, or TIMESTAMP, which SQLite gives NUMERIC affinity.
+func isDateTimeType(declaredType string) bool {
+	t := strings.ToUpper(declaredType)
+	return strings.Contains(t, "DATE") || strings.Contains(t, "TIME")
+}
+
+// Check the time formats of the options, keyed by table and time column.
+func checkTimeFormats(formats map[TableTimeColumn]string) error {
+	for key, format := range formats {
+		if _, err := parseTimeFormat(format); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("time column %s in table %s", key.TimeColumn, key.Table))
+		}
+	}
+	return nil
+}
+
+// Determine how the table/column stores times, as set by the TimeFormats
+// option, or else guessed from the type affinity of the column and, for REAL
+// and NUMERIC affinity, the stored values.  Epoch units not set are guessed
+// from the smallest value.  Guesses are logged, as they can go wrong, e.g.
+// on empty tables, and kept once made from stored values.
+func (seriesMan *sqliteTimeSeriesManager) timeEncoding(tableName string, timeColumn string) (timeEncoding, error) {
+	key := TableTimeColumn{Table: tableName, TimeColumn: timeColumn}
+	if cached, ok := seriesMan.encodings.Load(key); ok {
+		return cached.(timeEncoding), nil
+	}
+	encoding, configured, err := seriesMan.configuredTimeEncoding(tableName, timeColumn)
+	if err != nil {
+		return encoding, err
+	}
+	settled := true
+	if !configured {
+		if encoding, settled, err = seriesMan.guessTimeEncoding(tableName, timeColumn); err != nil {
+			return encoding, err
+		}
+	}
+	if encoding.kind == epochEncoding && encoding.unit == 0 {
+		var unitSettled bool
+		encoding.unit, unitSettled = seriesMan.guessTimeUnit(tableName, timeColumn)
+		settled = settled && unitSettled
+		configured = false
+	}
+
+	if !configured {
+		guess := guessKey{seriesMan.db, key}
+		if last, ok := guessedEncodings.Load(guess); !ok || last != encoding {
+			guessedEncodings.Store(guess, encoding)
+			sugar.Infow("guessed time format, set time-format to skip guessing",
+				"table", tableName, "column", timeColumn, "time-format", encoding.String())
+		}
+	}
+	if settled {
+		seriesMan.encodings.Store(key, encoding)
+	}
+	return encoding, nil
+}
+
+// Find the encoding set for the table/column by the TimeFormats option.
+func (seriesMan *sqliteTimeSeriesManager) configuredTimeEncoding(tableName string, timeColumn string) (timeEncoding, bool, error) {
+	for key, format := range seriesMan.opts.TimeFormats {
+		if strings.EqualFold(key.Table, tableName) && strings.EqualFold(key.TimeColumn, timeColumn) {
+			encoding, err := parseTimeFormat(format)
+			return encoding, true, err
+		}
+	}
+	return timeEncoding{}, false, nil
+}
+
+// Guess the encoding of the table/column from its type affinity, and for
+// REAL and NUMERIC affinity, from the stored values, reporting whether the
+// guess holds as values are added, rather than for lack of values.
+func (seriesMan *sqliteTimeSeriesManager) guessTimeEncoding(tableName string, timeColumn string) (timeEncoding, bool, error) {
+	columnType := seriesMan.getColumnType(tableName, timeColumn)
+	switch affinity := typeAffinity(columnType); affinity {
+	case "INTEGER":
+		return timeEncoding{kind: epochEncoding}, true, nil
+	case "TEXT":
+		return timeEncoding{kind: textEncoding}, true, nil
+	default:
+		least, err := seriesMan.leastNumber(tableName, timeColumn)
+		if err != nil {
+			return timeEncoding{}, false, err
+		}
+		switch {
+		case least.Valid && least.Float64 >= julianMinimum && least.Float64 < julianMaximum:
+			return timeEncoding{kind: julianEncoding}, true, nil
+		case least.Valid || affinity == "REAL":
+			return timeEncoding{kind: epochEncoding}, least.Valid, nil
+		case affinity == "NUMERIC":
+			// e.g. DATETIME columns holding text
+			return timeEncoding{kind: textEncoding}, false, nil
+		default:
+			return timeEncoding{}, false, errors.Errorf(
+				"cannot determine time encoding of time column %s in table %s, set its time format",
+				timeColumn, tableName)
+		}
+	}
+}
+
+// Guess the unit of epoch times in the table/column from the magnitude of
+// the smallest value, seconds without values, reporting whether there were
+// values to guess from.
+func (seriesMan *sqliteTimeSeriesManager) guessTimeUnit(tableName string, timeColumn string) (time.Duration, bool) {
+	least, err := seriesMan.leastNumber(tableName, timeColumn)
+	settled := err == nil && least.Valid
+	if scale, s := timecodex.NumberToScalar(int64(least.Float64)); s {
+		return time.Duration(scale) * time.Millisecond, settled
+	}
+	return time.Nanosecond, settled
+}
+
+// Find the smallest number stored in the table/column, ignoring text, or
+// NULL if none.
+func (seriesMan *sqliteTimeSeriesManager) leastNumber(tableName string, column string) (sql.NullFloat64, error) {
+	quotedColumn := quoteIdent(column)
+	query := fmt.Sprintf("SELECT min(%s) FROM %s WHERE typeof(%s) IN ('integer', 'real')",
+		quotedColumn, quoteIdent(tableName), quotedColumn)
+	var least sql.NullFloat64
+	if err := seriesMan.db.QueryRow(query).Scan(&least); err != nil {
+		return least, errors.Wrap(err, fmt.Sprintf("read least value of %s in table %s", column, tableName))
+	}
+	return least, nil
+}
+
+// Convert a Julian day to epoch millis.
+func julianToMillis(day float64) int64 {
+	return int64(math.Round((day - julianEpoch) * millisPerDay))
+}
+
+// Convert epoch millis to a Julian day.
+func millisToJulian(millis int64) float64 {
+	return float64(millis)/millisPerDay + julianEpoch
+}
+
+// Read a Julian day time as epoch millis.
+func readJulianMillis(input interface{}) (int64, error) {
+	switch v := input.(type) {
+	case *float64:
+		return julianToMillis(*v), nil
+	case *int64:
+		return julianToMillis(float64(*v)), nil
+	default:
+		return 0, errors.Errorf("cannot read Julian day from %v", input)
+	}
+}
+
+// Build a function reading numeric times in the epoch unit as epoch millis.
+func epochToMillis(unit time.Duration) func(input interface{}) (int64, error) {
+	return func(input interface{}) (int64, error) {
+		switch v := input.(type) {
+		case *int64:
+			if unit >= time.Millisecond {
+				return *v * int64(unit/time.Millisecond), nil
+			}
+			return *v / int64(time.Millisecond/unit), nil
+		case *float64:
+			return int64(math.Round(*v * float64(unit) / float64(time.Millisecond))), nil
+		default:
+			return 0, errors.Errorf("cannot cast %s %+v to millis", reflect.TypeOf(input), input)
+		}
+	}
+}
+
+// Read a time stored as text in the layout, or as by dateTimeToMillis
+// without one, interpreting times without a time zone in the configured
+// location.
+func (seriesMan *sqliteTimeSeriesManager) textToMillis(layout string) func(input interface{}) (int64, error) {
+	if layout == "" {
+		return seriesMan.dateTimeToMillis
+	}
+	return func(input interface{}) (int64, error) {
+		s, ok := input.(*string)
+		if !ok {
+			return 0, errors.Errorf("cannot cast time column of type %v to *string", reflect.TypeOf(input))
+		}
+		t, err := time.ParseInLocation(layout, *s, seriesMan.location())
+		if err != nil {
+			return 0, errors.Errorf("Cannot parse time: %v", err)
+		}
+		return t.UnixNano() / 1000000, nil
+	}
+}
+
+// Layouts of text times recognized by storedLayout, as written by the SQLite
+// date and time functions, and in ISO-8601.
+var storedLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05.000Z07:00",
+}
+
+// Detect the layout of text times stored in the table/column from the
+// smallest, or the empty string for empty columns or unrecognized layouts.
+// Layouts detected are kept.
+func (seriesMan *sqliteTimeSeriesManager) storedLayout(tableName string, column string) (string, error) {
+	key := TableTimeColumn{Table: tableName, TimeColumn: column}
+	if cached, ok := seriesMan.layouts.Load(key); ok {
+		return cached.(string), nil
+	}
+	quotedColumn := quoteIdent(column)
+	query := fmt.Sprintf("SELECT min(%s) FROM %s WHERE typeof(%s) = 'text'",
+		quotedColumn, quoteIdent(tableName), quotedColumn)
+	var least sql.NullString
+	if err := seriesMan.db.QueryRow(query).Scan(&least); err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("read least text of %s in table %s", column, tableName))
+	}
+	for _, layout := range storedLayouts {
+		if t, err := time.Parse(layout, least.String); err == nil && t.Format(layout) == least.String {
+			seriesMan.layouts.Store(key, layout)
+			return layout, nil
+		}
+	}
+	return "", nil
+}
+
+// Resolutions of layouts tried by formatBound, finest first.
+var layoutResolutions = []time.Duration{
+	time.Nanosecond, time.Microsecond, time.Millisecond, time.Second, time.Minute, time.Hour, 24 * time.Hour,
+}
+
+// Format a range bound in the layout, rounding upper bounds truncated by
+// the layout up to its next value.
+func formatBound(t time.Time, layout string, upper bool) string {
+	s := t.Format(layout)
+	if !upper {
+		return s
+	}
+	floor, err := time.ParseInLocation(layout, s, t.Location())
+	if err != nil || !floor.Before(t) {
+		return s
+	}
+	for _, d := range layoutResolutions {
+		if next := floor.Add(d).Format(layout); next != s {
+			return next
+		}
+	}
+	return s
+}
+
+// Select the time column for reading with the readers of getTimeToMillis.
+// The driver converts values of columns declared DATE, DATETIME, or
+// TIMESTAMP to Go times, guessing the unit of numbers and failing on text
+// in other layouts, so select those columns in an expression.
+func selectTime(quotedColumn string, declaredType string) string {
+	switch strings.ToLower(declaredType) {
+	case "date", "datetime", "timestamp":
+		// unary plus returns its operand unchanged, without a declared type
+		return "+" + quotedColumn
+	default:
+		return quotedColumn
+	}
+}
+
+// Check whether the smallest time stored in the table/column falls on or
+// after the time, in epoch millis, for discovering time columns.
+func (seriesMan *sqliteTimeSeriesManager) timesSince(tableName string, column string, millis int64) bool {
+	least, err := seriesMan.leastNumber(tableName, column)
+	if err != nil || !least.Valid {
+		return false
+	}
+	encoding, err := seriesMan.timeEncoding(tableName, column)
+	if err != nil {
+		return false
+	}
+	switch encoding.kind {
+	case julianEncoding:
+		return julianToMillis(least.Float64) >= millis
+	case epochEncoding:
+		leastMillis, err := epochToMillis(encoding.unit)(&least.Float64)
+		return err == nil && leastMillis >= millis
+	default:
+		return false
+	}
+}
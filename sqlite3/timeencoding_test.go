@@ -0,0 +1,274 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_typeAffinity(t *testing.T) {
+	cases := map[string]string{
+		"INT":              "INTEGER",
+		"bigint":           "INTEGER",
+		"UNSIGNED BIG INT": "INTEGER",
+		"VARCHAR(20)":      "TEXT",
+		"text":             "TEXT",
+		"":                 "BLOB",
+		"BLOB":             "BLOB",
+		"REAL":             "REAL",
+		"double precision": "REAL",
+		"FLOAT":            "REAL",
+		"NUMERIC":          "NUMERIC",
+		"DECIMAL(10,5)":    "NUMERIC",
+		"DATETIME":         "NUMERIC",
+		"TIMESTAMP":        "NUMERIC",
+		"DATE":             "NUMERIC",
+	}
+	for declared, expected := range cases {
+		if affinity := typeAffinity(declared); affinity != expected {
+			t.Fatalf(`Expected affinity %s for "%s", got %s`, expected, declared, affinity)
+		}
+	}
+}
+
+func createDbWithTimeTypes(t *testing.T) *sql.DB {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	queries := []string{
+		"CREATE TABLE times (x INT, bi BIGINT, r REAL, j REAL, n NUMERIC, ts TIMESTAMP, tt TIMESTAMP, d DATE)",
+		`INSERT INTO times VALUES (1, 1585699200000, 1585699200.25, julianday('2020-04-01'), 1585699200,
+			1585699200, '2020-04-01 00:00:00', '2020-04-01')`,
+		`INSERT INTO times VALUES (2, 1585785600000, 1585785600.75, julianday('2020-04-02 12:00'), 1585785600,
+			1585785600, '2020-04-02 00:00:00', '2020-04-02')`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	return db
+}
+
+func Test_GetTimeSeriesTimeTypes(t *testing.T) {
+	db := createDbWithTimeTypes(t)
+	fromTo := QueryRange{From: "2020-03-31T00:00:00Z", To: "2020-04-05T00:00:00Z"}
+	cases := map[string][]int64{
+		"bi": {1585699200000, 1585785600000},
+		"r":  {1585699200250, 1585785600750},
+		"j":  {1585699200000, 1585828800000},
+		"n":  {1585699200000, 1585785600000},
+		"ts": {1585699200000, 1585785600000},
+		"tt": {1585699200000, 1585785600000},
+		"d":  {1585699200000, 1585785600000},
+	}
+	for column, expected := range cases {
+		tsm := sqliteTimeSeriesManager{db: db, table: "times", timeColumn: column}
+		var ts map[string][]DataPoint
+		if err := tsm.GetTimeSeries(context.Background(), "x", &fromTo, nil, &ts); err != nil {
+			t.Fatalf(`Unexpected error querying time column %s "%+v"`, column, err)
+		}
+		var times []int64
+		for _, pt := range ts["x"] {
+			times = append(times, pt.Time)
+		}
+		if !reflect.DeepEqual(expected, times) {
+			t.Fatalf(`Expected times %v from time column %s, got %v`, expected, column, times)
+		}
+	}
+
+	// intervalize fractional times
+	for _, column := range []string{"r", "j"} {
+		tsm := sqliteTimeSeriesManager{db: db, table: "times", timeColumn: column}
+		var ts map[string][]DataPoint
+		if err := tsm.GetTimeSeries(context.Background(), "count(x) i(1d)", &fromTo, nil, &ts); err != nil {
+			t.Fatalf(`Unexpected error intervalizing time column %s "%+v"`, column, err)
+		}
+		expected := []DataPoint{{Time: 1585699200000, Value: 1}, {Time: 1585785600000, Value: 1}}
+		if !reflect.DeepEqual(expected, ts["count(x)"]) {
+			t.Fatalf(`Expected intervalized %v from time column %s, got %v`, expected, column, ts)
+		}
+	}
+}
+
+func Test_TimeFormatsOverride(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	db.Exec("CREATE TABLE empty (x INT, jd REAL)")
+	tsm := sqliteTimeSeriesManager{db: db, table: "empty", timeColumn: "jd"}
+	if from, err := tsm.formatUserTimeForQuery("empty", "jd", "2020-04-01", false); err != nil || from != int64(1585699200) {
+		t.Fatalf(`Expected empty REAL column to hold epoch seconds, got %v, %v`, from, err)
+	}
+	tsm.opts.TimeFormats = map[TableTimeColumn]string{{Table: "EMPTY", TimeColumn: "JD"}: "julian"}
+	if from, err := tsm.formatUserTimeForQuery("empty", "jd", "2020-04-01", false); err != nil || from != 2458940.5 {
+		t.Fatalf(`Expected Julian day time format, got %v, %v`, from, err)
+	}
+
+	opts := Options{TimeFormats: map[TableTimeColumn]string{{Table: "empty", TimeColumn: "jd"}: "stardate"}}
+	if _, err := NewWithOptions(":memory:", "empty", "jd", opts); err == nil {
+		t.Fatalf("Expected unknown time format to fail")
+	}
+}
+
+func Test_parseTimeFormat(t *testing.T) {
+	cases := map[string]timeEncoding{
+		"epoch":            {kind: epochEncoding},
+		"EPOCH_S":          {kind: epochEncoding, unit: time.Second},
+		"epoch_ms":         {kind: epochEncoding, unit: time.Millisecond},
+		"epoch_us":         {kind: epochEncoding, unit: time.Microsecond},
+		"epoch_ns":         {kind: epochEncoding, unit: time.Nanosecond},
+		"julian":           {kind: julianEncoding},
+		"iso8601":          {kind: textEncoding},
+		"01/02/2006 15:04": {kind: textEncoding, layout: "01/02/2006 15:04"},
+	}
+	for format, expected := range cases {
+		encoding, err := parseTimeFormat(format)
+		if err != nil || encoding != expected {
+			t.Fatalf(`Expected time format "%s" to parse as %+v, got %+v, %v`, format, expected, encoding, err)
+		}
+	}
+	if _, err := parseTimeFormat("epoch_fortnights"); err == nil {
+		t.Fatalf("Expected failure parsing a time format without layout elements")
+	}
+}
+
+func Test_GetTimeSeriesTimeFormats(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	queries := []string{
+		"CREATE TABLE times (x INT, ns INT, early INT, us TIMESTAMP, local DATETIME)",
+		`INSERT INTO times VALUES (1, 1585699200000000000, 86400000, 1585699200000000, '04/01/2020 00:00')`,
+		`INSERT INTO times VALUES (2, 1585785600000000000, 172800000, 1585785600000000, '04/02/2020 00:00')`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+
+	cases := []struct {
+		column   string
+		format   string
+		fromTo   QueryRange
+		expected []int64
+	}{
+		{"ns", "", QueryRange{From: "2020-03-31", To: "2020-04-05"}, []int64{1585699200000, 1585785600000}},
+		{"early", "epoch_ms", QueryRange{From: "1970-01-01", To: "1970-01-05"}, []int64{86400000, 172800000}},
+		{"us", "epoch_us", QueryRange{From: "2020-03-31", To: "2020-04-05"}, []int64{1585699200000, 1585785600000}},
+		{"local", "01/02/2006 15:04", QueryRange{From: "2020-03-31T00:00:00Z", To: "2020-04-05T00:00:00Z"},
+			[]int64{1585699200000, 1585785600000}},
+	}
+	for _, c := range cases {
+		tsm := sqliteTimeSeriesManager{db: db, table: "times", timeColumn: c.column}
+		if c.format != "" {
+			tsm.opts.TimeFormats = map[TableTimeColumn]string{{Table: "times", TimeColumn: c.column}: c.format}
+		}
+		var ts map[string][]DataPoint
+		if err := tsm.GetTimeSeries(context.Background(), "x", &c.fromTo, nil, &ts); err != nil {
+			t.Fatalf(`Unexpected error querying time column %s "%+v"`, c.column, err)
+		}
+		var times []int64
+		for _, pt := range ts["x"] {
+			times = append(times, pt.Time)
+		}
+		if !reflect.DeepEqual(c.expected, times) {
+			t.Fatalf(`Expected times %v from time column %s, got %v`, c.expected, c.column, times)
+		}
+	}
+}
+
+func Test_GetTimeSeriesStoredLayouts(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	queries := []string{
+		"CREATE TABLE times (x INT, dt DATETIME, d DATE, iso TEXT)",
+		`INSERT INTO times VALUES (1, '2020-04-01 05:00:00', '2020-04-01', '2020-04-01T05:00:00.000Z')`,
+		`INSERT INTO times VALUES (2, '2020-04-02 05:00:00', '2020-04-02', '2020-04-02T05:00:00.000Z')`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	chicago, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Fatalf("cannot load time zone: %v", err)
+	}
+
+	cases := []struct {
+		column   string
+		location *time.Location
+		fromTo   QueryRange
+		expected []int64
+	}{
+		// Grafana range strings against text in other layouts
+		{"dt", nil, QueryRange{From: "2020-04-01T00:00:00.000Z", To: "2020-04-02T00:00:00.000Z"},
+			[]int64{1585717200000}},
+		{"iso", nil, QueryRange{From: "2020-04-01T00:00:00.000Z", To: "2020-04-02T00:00:00.000Z"},
+			[]int64{1585717200000}},
+		// upper bounds round up to the next date
+		{"d", nil, QueryRange{From: "2020-04-01T00:00:00.000Z", To: "2020-04-02T12:00:00.000Z"},
+			[]int64{1585699200000, 1585785600000}},
+		// local times
+		{"dt", chicago, QueryRange{From: "2020-04-01T09:00:00.000Z", To: "2020-04-02T10:00:00.000Z"},
+			[]int64{1585735200000}},
+		{"d", chicago, QueryRange{From: "2020-04-01T05:00:00.000Z", To: "2020-04-02T04:00:00.000Z"},
+			[]int64{1585717200000}},
+	}
+	for _, c := range cases {
+		tsm := sqliteTimeSeriesManager{db: db, table: "times", timeColumn: c.column, opts: Options{Location: c.location}}
+		var ts map[string][]DataPoint
+		if err := tsm.GetTimeSeries(context.Background(), "x", &c.fromTo, nil, &ts); err != nil {
+			t.Fatalf(`Unexpected error querying time column %s "%+v"`, c.column, err)
+		}
+		var times []int64
+		for _, pt := range ts["x"] {
+			times = append(times, pt.Time)
+		}
+		if !reflect.DeepEqual(c.expected, times) {
+			t.Fatalf(`Expected times %v from time column %s in %v, got %v`, c.expected, c.column, c.location, times)
+		}
+	}
+}
+
+func Test_timeEncodingCached(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("CREATE TABLE tab (jd REAL, dt TEXT)"); err != nil {
+		t.Fatalf(`cannot create table for test: %+v`, err)
+	}
+	tsm := sqliteTimeSeriesManager{db: db, table: "tab", timeColumn: "jd"}
+
+	// guesses without values are not kept
+	if encoding, err := tsm.timeEncoding("tab", "jd"); err != nil || encoding.kind != epochEncoding {
+		t.Fatalf(`Expected epoch guess for empty column, got %+v, "%v"`, encoding, err)
+	}
+	db.Exec("INSERT INTO tab VALUES (2458940.5, '2020-04-01')")
+	if encoding, err := tsm.timeEncoding("tab", "jd"); err != nil || encoding.kind != julianEncoding {
+		t.Fatalf(`Expected Julian day guess once values are stored, got %+v, "%v"`, encoding, err)
+	}
+	if layout, err := tsm.storedLayout("tab", "dt"); err != nil || layout != "2006-01-02" {
+		t.Fatalf(`Expected date layout, got "%s", "%v"`, layout, err)
+	}
+
+	// guesses from values are kept without reading the table again
+	db.Exec("DROP TABLE tab")
+	if encoding, err := tsm.timeEncoding("tab", "jd"); err != nil || encoding.kind != julianEncoding {
+		t.Fatalf(`Expected cached Julian day encoding, got %+v, "%v"`, encoding, err)
+	}
+	if layout, err := tsm.storedLayout("tab", "dt"); err != nil || layout != "2006-01-02" {
+		t.Fatalf(`Expected cached date layout, got "%s", "%v"`, layout, err)
+	}
+}
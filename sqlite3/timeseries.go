@@ -1,19 +1,20 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"net/url"
 	"reflect"
-	"regexp"
 	"strconv"
 
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jonathanlb/sqlite32grafana/cli"
 	"github.com/jonathanlb/sqlite32grafana/timecodex"
-	_ "github.com/mattn/go-sqlite3" // db driver
 	"github.com/pkg/errors"
 )
 
@@ -21,107 +22,158 @@ type sqliteTimeSeriesManager struct {
 	db         *sql.DB
 	table      string
 	timeColumn string
+	opts       Options
+	// encodings and layouts keep the time encodings and stored text layouts
+	// of time columns, keyed by TableTimeColumn, once read from the data.
+	encodings sync.Map
+	layouts   sync.Map
 }
 
 var sugar = cli.Logger()
 
-var integerSQLTypes = NewSet("int", "integer", "tinyint")
-
-func (seriesMan *sqliteTimeSeriesManager) GetTimeSeries(target string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *map[string][]DataPoint) error {
-	fromTime, err := seriesMan.formatUserTimeForQuery(seriesMan.table, seriesMan.timeColumn, fromTo.From)
+func (seriesMan *sqliteTimeSeriesManager) GetTimeSeries(ctx context.Context, target string, fromTo *QueryRange, opts *TimeSeriesQueryOpts, dest *map[string][]DataPoint) error {
+	if query, ok := rawSQL(target); ok {
+		return seriesMan.getRawTimeSeries(ctx, query, fromTo, opts, dest)
+	}
+	tq, err := seriesMan.parseTarget(target, opts)
+	if err != nil {
+		return err
+	}
+	downsample, err := getDownsampler(tq.downsample)
 	if err != nil {
-		return errors.Wrap(err, "get from time for timeseries")
+		return err
 	}
-	toTime, err := seriesMan.formatUserTimeForQuery(seriesMan.table, seriesMan.timeColumn, fromTo.To)
+
+	fromTime, toTime, err := seriesMan.formatUserRangeForQuery(fromTo)
 	if err != nil {
-		return errors.Wrap(err, "get to time for timeseries")
+		return errors.Wrap(err, "timeseries")
 	}
 
-	timeReader := seriesMan.getTimeToMillis(seriesMan.table, seriesMan.timeColumn) // XXX memoize?
+	timeReader, err := seriesMan.getTimeToMillis(seriesMan.table, seriesMan.timeColumn)
+	if err != nil {
+		return errors.Wrap(err, "timeseries")
+	}
 
-	query, valueColumn, tagColumns := seriesMan.buildQuery(target, opts)
+	query, filterArgs, err := seriesMan.buildQuery(tq, opts)
+	if err != nil {
+		return errors.Wrap(err, "build timeseries query")
+	}
 	sugar.Debugw("timeseries query",
 		"query", query,
 		"from", fromTime,
-		"to", toTime)
-	if valueColumn == "" {
-		return errors.Errorf(`malformed target "%s"`, target)
-	}
+		"to", toTime,
+		"filters", filterArgs)
 
-	rows, err := seriesMan.db.Query(query, fromTime, toTime)
+	rows, err := seriesMan.db.QueryContext(ctx, query, append([]interface{}{fromTime, toTime}, filterArgs...)...)
 	if err != nil {
 		return errors.Wrap(err, "bad query for timeseries")
 	}
+	defer rows.Close()
 	rowCount := 0
 	result := make(map[string][]DataPoint)
-	var values []interface{}
-	tag := valueColumn // default value
+	numValues := len(tq.valuesSQL)
+	values := make([]interface{}, 1+numValues+len(tq.tags))
+	for i := range values {
+		values[i] = new(interface{})
+	}
 	for rows.Next() {
 		rowCount++
-		if values == nil {
-			values, err = getScanDest(rows)
-			if err != nil {
-				return err
-			}
+		if err := seriesMan.checkRowCount(rowCount); err != nil {
+			return err
 		}
-
 		if err := rows.Scan(values...); err != nil {
 			return errors.Errorf("Cannot scan row: %v", err)
 		}
 
-		timeMillis, err := timeReader(values[0])
+		timeMillis, err := timeReader(scanPointer(values[0]))
 		if err != nil {
 			return err
 		}
 
-		value, err := valueReader(values[1])
-		if err != nil {
-			return err
+		tags := make([]string, len(tq.tags))
+		for i, v := range values[1+numValues:] {
+			if p := scanPointer(v); p != nil {
+				tags[i] = toString(p)
+			}
 		}
-
-		toString := func(x interface{}) string {
-			switch v := x.(type) {
-			case *float64:
-				return strconv.FormatFloat(*v, 'f', 8, 64)
-			case *int64:
-				return strconv.FormatInt(*v, 10)
-			case *string:
-				return *v
-			default:
-				log.Panicf("Cannot cast %+v of type %s", x, v)
-				return ""
+		for i, name := range tq.valueNames {
+			value, err := valueReader(scanPointer(values[1+i]))
+			if err != nil {
+				return err
 			}
+			pt := DataPoint{Time: timeMillis, Value: value.Float64, Null: !value.Valid}
+			if pt.Null && tq.nulls == "skip" {
+				continue
+			}
+			if tq.nulls == "zero" {
+				pt.Null = false
+			}
+			tag := seriesName(name, numValues, tags)
+			result[tag] = append(result[tag], pt)
 		}
-
-		if len(tagColumns) > 0 {
-			var tagBuilder strings.Builder
-			tagBuilder.WriteString(toString(values[2]))
-			for _, i := range values[3:] {
-				tagBuilder.WriteString(" ")
-				tagBuilder.WriteString(toString(i))
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "read timeseries rows")
+	}
+	if tq.descending {
+		// series run forward in time, whichever rows were read
+		for _, pts := range result {
+			for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+				pts[i], pts[j] = pts[j], pts[i]
 			}
-			tag = tagBuilder.String()
 		}
-		newPoint := DataPoint{Time: timeMillis, Value: value}
-		result[tag] = append(result[tag], newPoint)
+	}
+	if tq.fill != "" {
+		if err := fillSeries(result, tq, fromTo, seriesMan.maxFillPoints(opts)); err != nil {
+			return err
+		}
+	}
+	for _, st := range tq.transforms {
+		for tag, pts := range result {
+			result[tag] = st.apply(pts)
+		}
+	}
+	if downsample != nil && opts != nil && opts.MaxDataPoints > 0 {
+		for tag, pts := range result {
+			result[tag] = downsampleNullable(downsample, pts, int(opts.MaxDataPoints))
+		}
 	}
 	*dest = result
 	sugar.Debugw("timeseries completed", "#rows", rowCount)
 	return nil
 }
 
-func (seriesMan *sqliteTimeSeriesManager) GetTagValues(tableName string, key string, dest *[]string) error {
-	return nil
+// Name the series of a value expression by its tag values, prefixed by the
+// expression when the target has several, or by the expression alone when
+// untagged.
+func seriesName(valueName string, numValues int, tags []string) string {
+	if len(tags) == 0 {
+		return valueName
+	}
+	name := strings.Join(tags, " ")
+	if numValues > 1 {
+		name = valueName + " " + name
+	}
+	return name
 }
 
 // New builds a new timeseries manager backed by the DB file and table with indexed time column.
 func New(dbFileName string, table string, timeColumn string) (TimeSeriesManager, error) {
-	db, err := sql.Open("sqlite3", dbFileName)
+	return NewWithOptions(dbFileName, table, timeColumn, Options{})
+}
+
+// NewWithOptions builds a new timeseries manager as New, opening and reading
+// the table according to the options.
+func NewWithOptions(dbFileName string, table string, timeColumn string, opts Options) (TimeSeriesManager, error) {
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
+	db, err := openDB(dbFileName, opts)
 	if err != nil {
 		return nil, err
 	}
 	//check presence of table and timeColumn
-	tsm := sqliteTimeSeriesManager{db: db, table: table, timeColumn: timeColumn}
+	tsm := sqliteTimeSeriesManager{db: db, table: table, timeColumn: timeColumn, opts: opts}
 	var schema []TagKey
 	if err := tsm.getSchema(table, &schema); err != nil {
 		return nil, errors.Wrap(err, fmt.Sprintf("cannot get schema for table %s", table))
@@ -137,61 +189,211 @@ func New(dbFileName string, table string, timeColumn string) (TimeSeriesManager,
 	return nil, errors.Errorf("cannot find time column %s in table with schema %+v", timeColumn, schema)
 }
 
-func (seriesMan *sqliteTimeSeriesManager) buildQuery(target string, opts *TimeSeriesQueryOpts) (string, string, []string) {
-	valueColumn, tagColumns, selectExpr, groupExpr := seriesMan.parseTarget(target)
-	var queryBuilder strings.Builder
+// Check the options for values out of range.
+func (opts Options) check() error {
+	if opts.MaxRows < 0 {
+		return errors.Errorf("max rows %d must not be negative", opts.MaxRows)
+	}
+	return checkTimeFormats(opts.TimeFormats)
+}
 
-	var orderBy string
-	if groupExpr == "" {
-		orderBy = seriesMan.timeColumn
-	} else {
-		orderBy = strings.Replace(groupExpr, " GROUP BY ", "", 1)
+// Open the DB file as set by the options, read-only unless writable.
+func openDB(dbFileName string, opts Options) (*sql.DB, error) {
+	params := url.Values{}
+	if !opts.Writable {
+		params.Set("mode", "ro")
+		params.Set("_query_only", "1")
+	}
+	if opts.Location != nil {
+		// have the driver read DATETIME columns in the location, too
+		params.Set("_loc", opts.Location.String())
+	}
+	dataSource := dbFileName
+	if len(params) > 0 {
+		dataSource = "file:" + (&url.URL{Path: dbFileName}).EscapedPath() + "?" + params.Encode()
+	}
+	return sql.Open(driverName, dataSource)
+}
+
+// Build the SQL query for the parsed target, returning the query and the
+// parameters to bind following the time range parameters.
+func (seriesMan *sqliteTimeSeriesManager) buildQuery(tq *targetQuery, opts *TimeSeriesQueryOpts) (string, []interface{}, error) {
+	filters := tq.filters
+	if opts != nil {
+		filters = append(append([]QueryFilter{}, opts.Filters...), tq.filters...)
+	}
+	filterExpr, filterArgs, err := seriesMan.buildFilters(filters)
+	if err != nil {
+		return "", nil, err
 	}
 
-	queryBuilder.WriteString(fmt.Sprintf(
-		"SELECT %s FROM %s WHERE %s >= ? AND %s < ?%s ORDER BY %s",
-		selectExpr, seriesMan.table, seriesMan.timeColumn, seriesMan.timeColumn, groupExpr, orderBy))
+	timeColumn := quoteIdent(seriesMan.timeColumn)
+	selected := append(append([]string{selectTime(timeColumn, seriesMan.getColumnType(seriesMan.table, seriesMan.timeColumn))},
+		tq.valuesSQL...), tq.tagsSQL...)
+	var groupBy string
+	orderBy := timeColumn
+	if tq.timeSQL != "" {
+		selected[0] = tq.timeSQL
+		groupBy = " GROUP BY " + strings.Join(append([]string{tq.timeSQL}, tq.tagsSQL...), ", ")
+		orderBy = tq.timeSQL
+	}
 
-	if opts != nil && opts.MaxDataPoints > 0 {
-		queryBuilder.WriteString(fmt.Sprintf(" LIMIT %d", opts.MaxDataPoints))
+	if tq.descending {
+		orderBy += " DESC"
 	}
 
-	return queryBuilder.String(), valueColumn, tagColumns
+	query := fmt.Sprintf(
+		"SELECT %s FROM %s WHERE %s >= ? AND %s < ?%s%s ORDER BY %s",
+		strings.Join(selected, ", "), quoteIdent(seriesMan.table),
+		timeColumn, timeColumn, filterExpr, groupBy, orderBy)
+	limit := tq.limit
+	if seriesMan.opts.MaxRows > 0 && (limit == 0 || limit > seriesMan.opts.MaxRows) {
+		// read one row past the limit to detect exceeding it
+		limit = seriesMan.opts.MaxRows + 1
+	}
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	if err := checkSingleSelect(query); err != nil {
+		return "", nil, err
+	}
+	return query, filterArgs, nil
 }
 
-func dateTimeToMillis(input interface{}) (int64, error) {
+// Fail once a query has read more rows than the configured maximum.
+func (seriesMan *sqliteTimeSeriesManager) checkRowCount(rowCount int) error {
+	if seriesMan.opts.MaxRows > 0 && rowCount > seriesMan.opts.MaxRows {
+		return errors.Errorf("query reads more than %d rows, narrow the time range or intervalize the target",
+			seriesMan.opts.MaxRows)
+	}
+	return nil
+}
+
+// SQL operators corresponding to Grafana ad hoc filter operators.
+var filterOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  "<",
+	">":  ">",
+	"=~": "REGEXP",
+	"!~": "NOT REGEXP",
+}
+
+// Translate Grafana ad hoc filters into parameterized SQL predicates to
+// append to the WHERE clause, checking each filter key against the table
+// columns.
+func (seriesMan *sqliteTimeSeriesManager) buildFilters(filters []QueryFilter) (string, []interface{}, error) {
+	if len(filters) == 0 {
+		return "", nil, nil
+	}
+
+	var schema []TagKey
+	if err := seriesMan.getSchema(seriesMan.table, &schema); err != nil {
+		return "", nil, err
+	}
+
+	var filterBuilder strings.Builder
+	args := make([]interface{}, 0, len(filters))
+	for _, filter := range filters {
+		op, ok := filterOperators[filter.Operator]
+		if !ok {
+			return "", nil, errors.Errorf(`unsupported filter operator "%s" on key "%s"`, filter.Operator, filter.Key)
+		}
+		column := findColumn(schema, filter.Key)
+		if column == "" {
+			return "", nil, errors.Errorf(`unknown filter key "%s"`, filter.Key)
+		}
+		filterBuilder.WriteString(fmt.Sprintf(" AND %s %s ?", quoteIdent(column), op))
+		args = append(args, filter.Value)
+	}
+	return filterBuilder.String(), args, nil
+}
+
+// Read a time stored as text, interpreting times without a time zone in the
+// configured location.
+func (seriesMan *sqliteTimeSeriesManager) dateTimeToMillis(input interface{}) (int64, error) {
 	dateStr, ok := input.(*string)
 	if !ok {
 		return 0,
 			errors.Errorf("cannot cast time column of type %v to *string", reflect.TypeOf(input))
 	}
 
-	ts, err := timecodex.StringToTime(*dateStr)
+	ts, err := timecodex.StringToTimeIn(*dateStr, seriesMan.location())
 	if err != nil {
 		return 0, errors.Errorf("Cannot parse time: %v", err)
 	}
-	millis := int64(ts.UnixNano() / 100000)
+	millis := int64(ts.UnixNano() / 1000000)
 	return millis, nil
 }
 
+// The layout of SQLite datetime() text, used for text times stored in the
+// configured location.
+const localDateTimeLayout = "2006-01-02 15:04:05"
+
+// The location interpreting stored text times lacking a time zone.
+func (seriesMan *sqliteTimeSeriesManager) location() *time.Location {
+	if seriesMan.opts.Location == nil {
+		return time.UTC
+	}
+	return seriesMan.opts.Location
+}
+
+// Convert the user-supplied time range to values comparable to the time
+// column.
+func (seriesMan *sqliteTimeSeriesManager) formatUserRangeForQuery(fromTo *QueryRange) (interface{}, interface{}, error) {
+	fromTime, err := seriesMan.formatUserTimeForQuery(seriesMan.table, seriesMan.timeColumn, fromTo.From, false)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get from time")
+	}
+	toTime, err := seriesMan.formatUserTimeForQuery(seriesMan.table, seriesMan.timeColumn, fromTo.To, true)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "get to time")
+	}
+	return fromTime, toTime, nil
+}
+
 // Convert user-supplied time string to one comparable to the stated type
-// of the column.
-func (seriesMan *sqliteTimeSeriesManager) formatUserTimeForQuery(tableName string, timeColumn string, timeStr string) (interface{}, error) {
-	columnType := seriesMan.getColumnType(tableName, timeColumn)
-	switch strings.ToLower(columnType) {
-	case "int":
+// of the column.  Upper bounds of ranges, compared exclusively, round up to
+// the resolution of text layouts, so that times truncated by the layout,
+// e.g. dates, are not excluded from the range they fall in.
+func (seriesMan *sqliteTimeSeriesManager) formatUserTimeForQuery(tableName string, timeColumn string, timeStr string, upper bool) (interface{}, error) {
+	encoding, err := seriesMan.timeEncoding(tableName, timeColumn)
+	if err != nil {
+		return nil, err
+	}
+	switch encoding.kind {
+	case epochEncoding:
 		t, err := timecodex.StringToTime(timeStr)
 		if err != nil {
 			return nil, err
 		}
-		unitGuess := seriesMan.guessTimeMetric(tableName, timeColumn, t)
-		return unitGuess, nil
-	case "datetime":
-		return timeStr, nil
-	case "text":
-		return timeStr, nil
+		return t.UnixNano() / int64(encoding.unit), nil
+	case julianEncoding:
+		t, err := timecodex.StringToTime(timeStr)
+		if err != nil {
+			return nil, err
+		}
+		return millisToJulian(t.UnixNano() / 1000000), nil
 	default:
-		return nil, errors.Errorf("unknown time type %s for time column %s in table %s", columnType, timeColumn, tableName)
+		layout := encoding.layout
+		if layout == "" {
+			if layout, err = seriesMan.storedLayout(tableName, timeColumn); err != nil {
+				return nil, err
+			}
+		}
+		if layout == "" {
+			if seriesMan.opts.Location == nil {
+				return timeStr, nil
+			}
+			layout = localDateTimeLayout
+		}
+		// compare against text stored in the layout, in the local time of
+		// the location
+		t, err := timecodex.StringToTime(timeStr)
+		if err != nil {
+			return nil, err
+		}
+		return formatBound(t.In(seriesMan.location()), layout, upper), nil
 	}
 }
 
@@ -200,9 +402,26 @@ func (seriesMan *sqliteTimeSeriesManager) getColumnType(tableName string, column
 	if err := seriesMan.getSchema(tableName, &schema); err != nil {
 		sugar.Panicf("cannot find column type for %s in table %s: %v", columnName, tableName, err)
 	}
-	for _, tag := range schema {
-		if strings.EqualFold(columnName, tag.Text) {
-			return tag.Type
+	return findType(schema, columnName)
+}
+
+// Find the column name as declared in the schema, ignoring case, or the empty
+// string if the schema has no such column.
+func findColumn(schema []TagKey, name string) string {
+	for _, col := range schema {
+		if strings.EqualFold(col.Text, name) {
+			return col.Text
+		}
+	}
+	return ""
+}
+
+// Find the declared type of the column in the schema, ignoring case, or the
+// empty string if the schema has no such column.
+func findType(schema []TagKey, name string) string {
+	for _, col := range schema {
+		if strings.EqualFold(col.Text, name) {
+			return col.Type
 		}
 	}
 	return ""
@@ -219,7 +438,8 @@ func getScanDest(rows *sql.Rows) ([]interface{}, error) {
 	for i, tp := range tt {
 		st := tp.ScanType()
 		if st == nil {
-			return nil, errors.Errorf("cannot infer column type for %q", tp.Name())
+			// NULL in the first row of an expression without declared type
+			st = reflect.TypeOf((*interface{})(nil)).Elem()
 		}
 		types[i] = st
 	}
@@ -233,16 +453,18 @@ func getScanDest(rows *sql.Rows) ([]interface{}, error) {
 // Build a slice of column names and reported types.  Note that Sqlite3 does
 // not validate that stored column values correspond to the stated type.
 func (seriesMan *sqliteTimeSeriesManager) getSchema(tableName string, dest *[]TagKey) error {
-	query := fmt.Sprintf("PRAGMA table_info(%s)", tableName)
-	sugar.Debugw("schema", "query", query)
-	schema, err := seriesMan.db.Query(query)
+	query := "SELECT name, type FROM pragma_table_info(?) ORDER BY cid"
+	sugar.Debugw("schema", "query", query, "table", tableName)
+	schema, err := seriesMan.db.Query(query, tableName)
 	if err != nil {
 		return err
 	}
 	defer schema.Close()
 	for schema.Next() {
-		var cid, name, ctype, notNull, defaultVal, pk string
-		schema.Scan(&cid, &name, &ctype, &notNull, &defaultVal, &pk)
+		var name, ctype string
+		if err := schema.Scan(&name, &ctype); err != nil {
+			return err
+		}
 		*dest = append(*dest, TagKey{ctype, name})
 	}
 
@@ -254,123 +476,152 @@ func (seriesMan *sqliteTimeSeriesManager) getSchema(tableName string, dest *[]Ta
 }
 
 // Determine which function to use to read a time value from the table/column
-// and translate to epoch millis for Grafana.
-func (seriesMan *sqliteTimeSeriesManager) getTimeToMillis(tableName string, timeColumn string) func(input interface{}) (int64, error) {
-	columnType := seriesMan.getColumnType(tableName, timeColumn)
-	switch strings.ToLower(columnType) {
-	case "int":
-		return seriesMan.columnToMillis(tableName, timeColumn)
-	case "datetime":
-		return dateTimeToMillis
-	case "text":
-		return dateTimeToMillis
+// and translate to epoch millis for Grafana.  The functions read values
+// scanned as by scanPointer.
+func (seriesMan *sqliteTimeSeriesManager) getTimeToMillis(tableName string, timeColumn string) (func(input interface{}) (int64, error), error) {
+	encoding, err := seriesMan.timeEncoding(tableName, timeColumn)
+	if err != nil {
+		return nil, err
+	}
+	switch encoding.kind {
+	case epochEncoding:
+		return epochToMillis(encoding.unit), nil
+	case julianEncoding:
+		return readJulianMillis, nil
 	default:
-		sugar.Panicf("unknown time type %s for time column %s in table %s", columnType, timeColumn, tableName)
-		return nil
+		return seriesMan.textToMillis(encoding.layout), nil
 	}
 }
 
-// Take a time value and guess a numeric value useable for the specified
-// timeColumn.
-func (seriesMan *sqliteTimeSeriesManager) guessTimeMetric(tableName string, timeColumn string, t time.Time) int64 {
-	scale, s := seriesMan.guessTimeScalar(tableName, timeColumn)
-	if s {
-		return t.Unix() * 1000 / scale
+// Parse the target against the table schema.  The query options size the
+// intervals of targets with the "auto" option.
+func (seriesMan *sqliteTimeSeriesManager) parseTarget(target string, opts *TimeSeriesQueryOpts) (*targetQuery, error) {
+	var schema []TagKey
+	if err := seriesMan.getSchema(seriesMan.table, &schema); err != nil {
+		return nil, err
 	}
-	return t.Unix() * 1000 * scale
-}
-
-// Return a value to scale (multiply) numeric values from the table/column to
-// arrive at epoch millis.
-func (seriesMan *sqliteTimeSeriesManager) guessTimeScalar(tableName string, timeColumn string) (int64, bool) {
-	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY %s LIMIT 1", timeColumn, tableName, timeColumn)
-	row := seriesMan.db.QueryRow(query)
-	var t int64
-	row.Scan(&t)
-	return timecodex.NumberToScalar(t)
-}
-
-// Build a function to translate numeric time values from the table/column
-// to epoch millis.
-func (seriesMan *sqliteTimeSeriesManager) columnToMillis(tableName string, timeColumn string) func(input interface{}) (int64, error) {
-	scale, s := seriesMan.guessTimeScalar(tableName, timeColumn)
-
-	errFunc := func(input interface{}) (int64, error) {
-		return 0, errors.Errorf("cannot cast %s %+v to millis", reflect.TypeOf(input), input)
+	timeBucket := func(duration string) (string, time.Duration, error) {
+		var d time.Duration
+		var err error
+		if duration == "" {
+			d, err = seriesMan.autoInterval(opts)
+		} else {
+			d, err = timecodex.ParseDuration(duration)
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		sql, err := seriesMan.timeBucketExpr(seriesMan.timeColumn, d)
+		return sql, d, err
+	}
+	if opts == nil || !opts.Data.isStructured() {
+		return parseTargetQuery(target, schema, seriesMan.timeColumn, timeBucket)
 	}
 
-	if s {
-		return func(input interface{}) (int64, error) {
-			millis, ok := input.(*int64)
-			if ok {
-				return *millis * scale, nil
-			}
-			return errFunc(nil)
+	if strings.TrimSpace(target) != "" {
+		return nil, errors.Errorf(`target "%s" conflicts with the values of the target data`, target)
+	}
+	tq, err := parseTargetData(opts.Data, schema, seriesMan.timeColumn, timeBucket)
+	if err != nil {
+		return nil, err
+	}
+	for i, filter := range tq.filters {
+		if _, _, err := seriesMan.buildFilters([]QueryFilter{filter}); err != nil {
+			return nil, &TargetDataError{Field: fmt.Sprintf("filters[%d]", i), Err: err}
 		}
 	}
-	return errFunc
+	return tq, nil
 }
 
-// Break up the target into the value column, tag columns, selected columns,
-// and group-by expression if necessary
-func (seriesMan *sqliteTimeSeriesManager) parseTarget(target string) (string, []string, string, string) {
-	valueColumn, tagColumns := seriesMan.target2tokens(target)
-	var colBuilder strings.Builder
-	var groupBy string
-
-	// Scan tagOptions for a token of the form "t(...)"
-	// signalling time-intervalization.  If one is present, return it stripped
-	// of the t() and substituting "?" for the time column name; the the remaining
-	// time options.  Otherwise, return the empty string and the original options.
-	getTimeExpr := func(tagOptions []string) (string, []string) {
-		for i, tag := range tagOptions {
-			if strings.HasPrefix(tag, "t(") && strings.HasSuffix(tag, ")") {
-				remainTags := append(tagOptions[:i], tagOptions[i+1:]...)
-				timeF := strings.ReplaceAll(tag[2:len(tag)-1], "?", seriesMan.timeColumn)
-				return timeF, remainTags
-			}
+// Determine the interval Grafana requested for the query, rounded up to a
+// multiple of the time column unit.
+func (seriesMan *sqliteTimeSeriesManager) autoInterval(opts *TimeSeriesQueryOpts) (time.Duration, error) {
+	var d time.Duration
+	if opts != nil && opts.IntervalMs > 0 {
+		d = time.Duration(opts.IntervalMs) * time.Millisecond
+	} else if opts != nil && opts.Interval != "" {
+		var err error
+		if d, err = timecodex.ParseDuration(opts.Interval); err != nil {
+			return 0, err
 		}
-		return "", tagOptions
+	} else {
+		return 0, errors.New("auto interval requires the query interval or intervalMs")
 	}
 
-	timeExp, tagColumns := getTimeExpr(tagColumns)
-	if timeExp == "" {
-		colBuilder.WriteString(fmt.Sprintf("%s, %s", seriesMan.timeColumn, valueColumn))
-	} else {
-		colBuilder.WriteString(fmt.Sprintf("%s, %s", timeExp, valueColumn))
-		groupBy = fmt.Sprintf(" GROUP BY %s", timeExp)
+	unit, err := seriesMan.timeUnit(seriesMan.timeColumn)
+	if err != nil {
+		return 0, err
+	}
+	if rem := d % unit; rem != 0 {
+		d += unit - rem
 	}
+	return d, nil
+}
 
-	for _, i := range tagColumns {
-		colBuilder.WriteString(", ")
-		colBuilder.WriteString(i)
+// Build a SQL expression rounding the time column down to a multiple of the
+// duration, in the same encoding as the time column, for grouping
+// observations into intervals.
+func (seriesMan *sqliteTimeSeriesManager) timeBucketExpr(timeColumn string, d time.Duration) (string, error) {
+	if d <= 0 {
+		return "", errors.Errorf("interval %v must be positive", d)
 	}
+	unit, err := seriesMan.timeUnit(timeColumn)
+	if err != nil {
+		return "", err
+	}
+	if d%unit != 0 {
+		return "", errors.Errorf("interval %v is not a multiple of the time column unit %v", d, unit)
+	}
+	n := int64(d / unit)
 
-	return valueColumn, tagColumns, colBuilder.String(), groupBy
+	quotedColumn := quoteIdent(timeColumn)
+	encoding, err := seriesMan.timeEncoding(seriesMan.table, timeColumn)
+	if err != nil {
+		return "", err
+	}
+	switch encoding.kind {
+	case epochEncoding:
+		if typeAffinity(seriesMan.getColumnType(seriesMan.table, timeColumn)) == "INTEGER" {
+			return fmt.Sprintf("%d*(%s/%d)", n, quotedColumn, n), nil
+		}
+		// round down fractional times, too
+		return fmt.Sprintf("%d*CAST(%s/%d AS INTEGER)", n, quotedColumn, n), nil
+	case julianEncoding:
+		return fmt.Sprintf("%v+%d*CAST((%s-%v)*%d/%d AS INTEGER)/%d.0",
+			julianEpoch, n, quotedColumn, julianEpoch, millisPerDay, n, millisPerDay), nil
+	case textEncoding:
+		if encoding.layout != "" {
+			return "", errors.Errorf("cannot intervalize time column %s stored in layout %s",
+				timeColumn, encoding.layout)
+		}
+		fallthrough
+	default:
+		return fmt.Sprintf("datetime(%d*(CAST(strftime('%%s', %s) AS INTEGER)/%d), 'unixepoch')",
+			n, quotedColumn, n), nil
+	}
 }
 
-// Parse the target as "valueColumn [tagOptions]*"
-func (seriesMan *sqliteTimeSeriesManager) target2tokens(target string) (string, []string) {
-	tokens := strings.Fields(target)
-	var valueColumn string
-	if len(tokens) > 0 {
-		valueColumn = tokens[0]
+// Find the smallest duration distinguishable by the time column.
+func (seriesMan *sqliteTimeSeriesManager) timeUnit(timeColumn string) (time.Duration, error) {
+	encoding, err := seriesMan.timeEncoding(seriesMan.table, timeColumn)
+	if err != nil {
+		return 0, err
 	}
-	var tagOptions []string
-	if len(tokens) > 1 {
-		tagOptions = tokens[1:]
+	switch encoding.kind {
+	case epochEncoding:
+		return encoding.unit, nil
+	case julianEncoding:
+		return time.Millisecond, nil
+	default:
+		return time.Second, nil
 	}
-	return valueColumn, tagOptions
 }
 
-var yyyymmdd = regexp.MustCompile(`^[0-9]{2,4}[/\- ][0-9]{1,2}[/\- ][0-9]{1,2}$`)
-var intStr = regexp.MustCompile(`^[0-9]+$`)
-
 func sql2grafanaType(sqlType string) string {
-	switch strings.ToLower(sqlType) {
-	case "int":
+	switch typeAffinity(sqlType) {
+	case "INTEGER", "REAL":
 		return "number"
-	case "text":
+	case "TEXT":
 		return "string"
 	default:
 		sugar.Debugw("Unknown sql column",
@@ -379,15 +630,31 @@ func sql2grafanaType(sqlType string) string {
 	}
 }
 
-func valueReader(value interface{}) (float64, error) {
-	x, ok := value.(*float64)
-	if !ok {
-		i, ok := value.(*int64)
-		if !ok {
-			return 0.0, errors.Errorf(`cannot coerce value "%v" to float64`, value)
-		}
-		f := float64(*i)
-		return f, nil
+// Render a scanned column value as a string, for tagging series.
+func toString(x interface{}) string {
+	switch v := x.(type) {
+	case *float64:
+		return strconv.FormatFloat(*v, 'f', 8, 64)
+	case *int64:
+		return strconv.FormatInt(*v, 10)
+	case *string:
+		return *v
+	default:
+		log.Panicf("Cannot cast %+v of type %s", x, v)
+		return ""
+	}
+}
+
+// Read a scanned value as a number, or as invalid for NULL.
+func valueReader(value interface{}) (sql.NullFloat64, error) {
+	switch v := value.(type) {
+	case nil:
+		return sql.NullFloat64{}, nil
+	case *float64:
+		return sql.NullFloat64{Float64: *v, Valid: true}, nil
+	case *int64:
+		return sql.NullFloat64{Float64: float64(*v), Valid: true}, nil
+	default:
+		return sql.NullFloat64{}, errors.Errorf(`cannot coerce value "%v" to float64`, value)
 	}
-	return *x, nil
 }
@@ -1,12 +1,14 @@
 package sqlite3
 
 import (
+	"context"
 	"database/sql"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -36,7 +38,7 @@ func Test_CreateFromFileNames(t *testing.T) {
 func Test_formatUserTimeForQuery(t *testing.T) {
 	db := createDbWithTable(t)
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
-	ts, err := tsm.formatUserTimeForQuery("tsTab", "ts", "2020-05-01")
+	ts, err := tsm.formatUserTimeForQuery("tsTab", "ts", "2020-05-01", false)
 	if err != nil {
 		t.Fatalf(`cannot parse time "2020-05-01" for int time: %+v`, err)
 	}
@@ -48,7 +50,7 @@ func Test_formatUserTimeForQuery(t *testing.T) {
 		t.Fatalf(`cannot parse time "2020-05-01" for int time: %+v, expected greater than 1588204800`, ts)
 	}
 
-	ts, err = tsm.formatUserTimeForQuery("tsTab", "ts", "1")
+	ts, err = tsm.formatUserTimeForQuery("tsTab", "ts", "1", false)
 	if err != nil {
 		t.Fatalf(`cannot parse time "1" for text time: %+v`, err)
 	}
@@ -60,7 +62,7 @@ func Test_formatUserTimeForQuery(t *testing.T) {
 		t.Fatalf(`cannot parse time "1" for string time: %+v`, ts)
 	}
 
-	ts, err = tsm.formatUserTimeForQuery("tsTab", "tag", "2020-05-01")
+	ts, err = tsm.formatUserTimeForQuery("tsTab", "tag", "2020-05-01", false)
 	if err != nil {
 		t.Fatalf(`cannot parse time "2020-05-01" for text time: %+v`, err)
 	}
@@ -72,7 +74,7 @@ func Test_formatUserTimeForQuery(t *testing.T) {
 		t.Fatalf(`cannot parse time "2020-05-01" for string time: %+v`, ts)
 	}
 
-	ts, err = tsm.formatUserTimeForQuery("tsTab", "dt", "2020-05-01")
+	ts, err = tsm.formatUserTimeForQuery("tsTab", "dt", "2020-05-01", false)
 	tt = reflect.TypeOf(ts).String()
 	if tt != "string" {
 		t.Fatalf(`expected "2020-05-01" for string time to be of type datetime not %s`, tt)
@@ -85,22 +87,21 @@ func Test_formatUserTimeForQuery(t *testing.T) {
 func Test_selectFromTarget(t *testing.T) {
 	db := createDbWithTable(t)
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
-	v, tagColumns, selected, groupBy := tsm.parseTarget("x tag t(datetime(t,'unixepoch'))")
+	tq, err := tsm.parseTarget("x tag t(datetime(ts,'unixepoch'))", nil)
+	if err != nil {
+		t.Fatalf(`Unexpected error parsing target "%+v"`, err)
+	}
 	expectedTags := []string{"tag"}
-	expectedSelected := "datetime(t,'unixepoch'), x, tag"
-	expectedGroupBy := " GROUP BY datetime(t,'unixepoch')"
+	expectedTime := `datetime("ts", 'unixepoch')`
 
-	if v != "x" {
-		t.Fatalf(`Expected parsed value target "x", got "%s"`, v)
-	}
-	if !reflect.DeepEqual(expectedTags, tagColumns) {
-		t.Fatalf(`Expected tag columns "%s", got "%s"`, expectedTags, tagColumns)
+	if !reflect.DeepEqual([]string{"x"}, tq.valueNames) || !reflect.DeepEqual([]string{`"x"`}, tq.valuesSQL) {
+		t.Fatalf(`Expected parsed value target "x", got "%s", "%s"`, tq.valueNames, tq.valuesSQL)
 	}
-	if selected != expectedSelected {
-		t.Fatalf(`Expected SELECT clause "%s", got "%s"`, expectedSelected, selected)
+	if !reflect.DeepEqual(expectedTags, tq.tags) {
+		t.Fatalf(`Expected tag columns "%s", got "%s"`, expectedTags, tq.tags)
 	}
-	if groupBy != expectedGroupBy {
-		t.Fatalf(`Expected GROUP BY clause "%s", got "%s"`, expectedGroupBy, groupBy)
+	if tq.timeSQL != expectedTime {
+		t.Fatalf(`Expected time expression "%s", got "%s"`, expectedTime, tq.timeSQL)
 	}
 }
 
@@ -109,7 +110,7 @@ func Test_GetTimeSeries(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "0", To: "10"}
-	err := tsm.GetTimeSeries("x tag", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x tag", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
@@ -128,7 +129,7 @@ func Test_GetTimeSeriesFloat(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "0", To: "10"}
-	err := tsm.GetTimeSeries("f tag", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "f tag", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
@@ -147,7 +148,7 @@ func Test_GetTimeSeriesWithIntTag(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "0", To: "10"}
-	err := tsm.GetTimeSeries("x x", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x x", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
@@ -161,7 +162,7 @@ func Test_GetTimeSeriesWithFloatTag(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "0", To: "10"}
-	err := tsm.GetTimeSeries("x f", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x f", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
@@ -170,22 +171,106 @@ func Test_GetTimeSeriesWithFloatTag(t *testing.T) {
 	}
 }
 
+func Test_GetTimeSeriesMultipleValues(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	var ts map[string][]DataPoint
+	fromTo := QueryRange{From: "0", To: "10"}
+	err := tsm.GetTimeSeries(context.Background(), "min(x),max(x) tag t(2*(?/2))", &fromTo, nil, &ts)
+	if err != nil {
+		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
+	}
+	if len(ts) != 4 ||
+		!reflect.DeepEqual(ts["min(x) a"], []DataPoint{{Time: 0, Value: 100.}, {Time: 2000, Value: 300.}}) ||
+		!reflect.DeepEqual(ts["max(x) b"], []DataPoint{{Time: 2000, Value: 200.}, {Time: 4000, Value: 400.}}) {
+		t.Fatalf(`Unexpected timeseries response "%+v"`, ts)
+	}
+
+	err = tsm.GetTimeSeries(context.Background(), "min(x), max(x)", &fromTo, nil, &ts)
+	if err != nil {
+		t.Fatalf(`Unexpected error querying untagged timeseries "%+v"`, err)
+	}
+	if len(ts) != 2 || len(ts["min(x)"]) != 1 || len(ts["max(x)"]) != 1 ||
+		ts["min(x)"][0].Value != 100. || ts["max(x)"][0].Value != 400. {
+		t.Fatalf(`Unexpected untagged timeseries response "%+v"`, ts)
+	}
+}
+
+func Test_GetTimeSeriesNulls(t *testing.T) {
+	db := createDbWithTable(t)
+	if _, err := db.Exec("INSERT INTO tsTab (ts, x, tag) VALUES (5, NULL, NULL)"); err != nil {
+		t.Fatalf(`cannot insert NULL row: %+v`, err)
+	}
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "4", To: "10"}
+	cases := map[string][]DataPoint{
+		"x":             {{Time: 4000, Value: 400}, {Time: 5000, Null: true}},
+		"x nulls(keep)": {{Time: 4000, Value: 400}, {Time: 5000, Null: true}},
+		"x nulls(skip)": {{Time: 4000, Value: 400}},
+		"x nulls(zero)": {{Time: 4000, Value: 400}, {Time: 5000}},
+	}
+	for target, expected := range cases {
+		var ts map[string][]DataPoint
+		if err := tsm.GetTimeSeries(context.Background(), target, &fromTo, nil, &ts); err != nil {
+			t.Fatalf(`Unexpected error querying "%s": %+v`, target, err)
+		}
+		if !reflect.DeepEqual(expected, ts["x"]) {
+			t.Fatalf(`Expected "%s" to give %+v, got %+v`, target, expected, ts)
+		}
+	}
+
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "x tag", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error querying NULL tags: %+v`, err)
+	}
+	if !reflect.DeepEqual([]DataPoint{{Time: 5000, Null: true}}, ts[""]) {
+		t.Fatalf(`Expected the series of the NULL tag to hold a null, got %+v`, ts)
+	}
+
+	var table Table
+	fromTo = QueryRange{From: "5", To: "10"}
+	if err := tsm.GetTable(context.Background(), "sum(x) tag", &fromTo, nil, &table); err != nil {
+		t.Fatalf(`Unexpected error querying NULL table row: %+v`, err)
+	}
+	if !reflect.DeepEqual([][]interface{}{{int64(5000), nil, nil}}, table.Rows) {
+		t.Fatalf(`Expected NULL table values, got %+v`, table.Rows)
+	}
+}
+
 func Test_GetTimeSeriesLimit(t *testing.T) {
 	db := createDbWithTable(t)
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "0", To: "10"}
 	opts := TimeSeriesQueryOpts{MaxDataPoints: 2}
-	err := tsm.GetTimeSeries("x tag", &fromTo, &opts, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x tag", &fromTo, &opts, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
+	// the limit applies to each series, rather than to the rows
 	if ts == nil || len(ts) != 2 ||
-		len(ts["a"]) != 1 || len(ts["b"]) != 1 ||
-		ts["a"][0] != (DataPoint{Time: 1000, Value: 100.}) ||
-		ts["b"][0] != (DataPoint{Time: 2000, Value: 200.}) {
+		len(ts["a"]) != 2 || len(ts["b"]) != 2 {
 		t.Fatalf(`Unexpected timeseries with limit 2 response "%+v"`, ts)
 	}
+
+	err = tsm.GetTimeSeries(context.Background(), "x downsample(avg)", &fromTo, &opts, &ts)
+	if err != nil {
+		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
+	}
+	expected := []DataPoint{{Time: 1500, Value: 150.}, {Time: 3500, Value: 350.}}
+	if !reflect.DeepEqual(expected, ts["x"]) {
+		t.Fatalf(`Expected downsampled timeseries "%+v", but got "%+v"`, expected, ts)
+	}
+
+	err = tsm.GetTimeSeries(context.Background(), "x downsample(none)", &fromTo, &opts, &ts)
+	if err != nil || len(ts["x"]) != 4 {
+		t.Fatalf(`Expected timeseries without downsampling, but got "%+v", %v`, ts, err)
+	}
+
+	err = tsm.GetTimeSeries(context.Background(), "x downsample(fancy)", &fromTo, &opts, &ts)
+	if err == nil {
+		t.Fatalf("Expected error downsampling with unknown algorithm")
+	}
 }
 
 func Test_GetTimeSeriesParsingRange(t *testing.T) {
@@ -193,7 +278,7 @@ func Test_GetTimeSeriesParsingRange(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "1969-01-01", To: "1971-12-31"}
-	err := tsm.GetTimeSeries("x tag", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x tag", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries with datetime "%+v"`, err)
 	}
@@ -212,7 +297,7 @@ func Test_GetTimeSeriesWithTimeRange(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "2", To: "4"}
-	err := tsm.GetTimeSeries("x", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
@@ -228,7 +313,7 @@ func Test_GetTimeSeriesWithDatetimeIndex(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "dt"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "2020-04-02", To: "2020-04-04"}
-	err := tsm.GetTimeSeries("x", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "x", &fromTo, nil, &ts)
 	if err != nil {
 		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
@@ -280,7 +365,7 @@ func Test_GetTimeSeriesFailsOnUnspecifiedValueColumn(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 	var ts map[string][]DataPoint
 	fromTo := QueryRange{From: "0", To: "10"}
-	err := tsm.GetTimeSeries("", &fromTo, nil, &ts)
+	err := tsm.GetTimeSeries(context.Background(), "", &fromTo, nil, &ts)
 	if err == nil || !strings.HasPrefix(err.Error(), "malformed target") {
 		t.Fatalf(`Unexpected error querying missing table "%+v"`, err)
 	}
@@ -290,8 +375,9 @@ func Test_buildQuery(t *testing.T) {
 	db := createDbWithTable(t)
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 
-	query, valueColumn, tags := tsm.buildQuery("x", nil)
-	expectedQuery := "SELECT ts, x FROM tsTab WHERE ts >= ? AND ts < ? ORDER BY ts"
+	query, _, tq, _ := buildTargetQuery(&tsm, "x", nil)
+	valueColumn, tags := tq.valueNames[0], tq.tags
+	expectedQuery := `SELECT "ts", "x" FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? ORDER BY "ts"`
 	expectedValue := "x"
 	expectedTags := []string{}
 	if query != expectedQuery {
@@ -310,8 +396,9 @@ func Test_buildQueryTimeIntervalized(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 
 	// intervalize by hour, presuming a seconds time column
-	query, valueColumn, tags := tsm.buildQuery("x t(3600*(?/3600))", nil)
-	expectedQuery := "SELECT 3600*(ts/3600), x FROM tsTab WHERE ts >= ? AND ts < ? GROUP BY 3600*(ts/3600) ORDER BY 3600*(ts/3600)"
+	query, _, tq, _ := buildTargetQuery(&tsm, "x t(3600*(?/3600))", nil)
+	valueColumn, tags := tq.valueNames[0], tq.tags
+	expectedQuery := `SELECT 3600*("ts"/3600), "x" FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? GROUP BY 3600*("ts"/3600) ORDER BY 3600*("ts"/3600)`
 	expectedValue := "x"
 	expectedTags := []string{}
 	if query != expectedQuery {
@@ -330,8 +417,9 @@ func Test_buildQuerySummarized(t *testing.T) {
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 
 	// intervalize by hour, presuming a seconds time column
-	query, valueColumn, tags := tsm.buildQuery("count(x) t(3600*(ts/3600))", nil)
-	expectedQuery := "SELECT 3600*(ts/3600), count(x) FROM tsTab WHERE ts >= ? AND ts < ? GROUP BY 3600*(ts/3600) ORDER BY 3600*(ts/3600)"
+	query, _, tq, _ := buildTargetQuery(&tsm, "count(x) t(3600*(ts/3600))", nil)
+	valueColumn, tags := tq.valueNames[0], tq.tags
+	expectedQuery := `SELECT 3600*("ts"/3600), count("x") FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? GROUP BY 3600*("ts"/3600) ORDER BY 3600*("ts"/3600)`
 	expectedValue := "count(x)"
 	expectedTags := []string{}
 	if query != expectedQuery {
@@ -345,74 +433,209 @@ func Test_buildQuerySummarized(t *testing.T) {
 	}
 }
 
-func Test_guessTimeScalar(t *testing.T) {
-	db, err := sql.Open("sqlite3", ":memory:")
+func Test_buildQueryDurationIntervalized(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+
+	query, _, _, err := buildTargetQuery(&tsm, "count(x) i(1h) tag", nil)
 	if err != nil {
-		t.Fatal("Cannot create in-memory sqlite DB")
+		t.Fatalf(`Unexpected error building query "%+v"`, err)
+	}
+	expectedQuery := `SELECT 3600*("ts"/3600), count("x"), "tag" FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? GROUP BY 3600*("ts"/3600), "tag" ORDER BY 3600*("ts"/3600)`
+	if query != expectedQuery {
+		t.Fatalf(`Expected query "%s", but got "%s"`, expectedQuery, query)
+	}
+
+	for _, target := range []string{"x i(1500ms)", "x i(-1s)", "x i(soon)"} {
+		if _, _, _, err := buildTargetQuery(&tsm, target, nil); err == nil {
+			t.Fatalf(`Expected error building query for "%s"`, target)
+		}
+	}
+}
+
+func Test_buildQueryAutoIntervalized(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+
+	opts := TimeSeriesQueryOpts{Interval: "1m", IntervalMs: 1500}
+	query, _, _, err := buildTargetQuery(&tsm, "avg(x) auto", &opts)
+	if err != nil {
+		t.Fatalf(`Unexpected error building query "%+v"`, err)
+	}
+	// intervalMs has precedence, rounded up to the seconds time column
+	expectedQuery := `SELECT 2*("ts"/2), avg("x") FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? GROUP BY 2*("ts"/2) ORDER BY 2*("ts"/2)`
+	if query != expectedQuery {
+		t.Fatalf(`Expected query "%s", but got "%s"`, expectedQuery, query)
 	}
 
+	opts = TimeSeriesQueryOpts{Interval: "1m"}
+	query, _, _, err = buildTargetQuery(&tsm, "avg(x) auto", &opts)
+	if err != nil {
+		t.Fatalf(`Unexpected error building query "%+v"`, err)
+	}
+	expectedQuery = `SELECT 60*("ts"/60), avg("x") FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? GROUP BY 60*("ts"/60) ORDER BY 60*("ts"/60)`
+	if query != expectedQuery {
+		t.Fatalf(`Expected query "%s", but got "%s"`, expectedQuery, query)
+	}
+
+	if _, _, _, err := buildTargetQuery(&tsm, "avg(x) auto", nil); err == nil {
+		t.Fatalf("Expected error building auto query without an interval")
+	}
+}
+
+func Test_timeBucketExpr(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
 	queries := []string{
 		"CREATE TABLE tsTab (seconds INT, millis int, nanos INT, dt DATETIME)",
 		"INSERT INTO tsTab (seconds, millis, nanos, dt) VALUES (1585742400, 1585742400000, 1585742400000000000, '2020-04-01 12:00')",
-		"INSERT INTO tsTab (seconds, millis, nanos, dt) VALUES (1585828800, 1585828800000, 1585828800000000000, '2020-04-02 12:00')",
 	}
 	for _, q := range queries {
 		if _, err := db.Exec(q); err != nil {
 			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
 		}
 	}
-	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "dt"}
-	scalar, sign := tsm.guessTimeScalar("tsTab", "seconds")
-	if scalar != 1000 || !sign {
-		t.Fatalf(`expected scalar, sign of 1000, true, but got %d, %t`, scalar, sign)
+
+	expected := map[string]string{
+		"seconds": `10*("seconds"/10)`,
+		"millis":  `10000*("millis"/10000)`,
+		"nanos":   `10000000000*("nanos"/10000000000)`,
+		"dt":      `datetime(10*(CAST(strftime('%s', "dt") AS INTEGER)/10), 'unixepoch')`,
 	}
+	for column, expectedExpr := range expected {
+		tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: column}
+		expr, err := tsm.timeBucketExpr(column, 10*time.Second)
+		if err != nil || expr != expectedExpr {
+			t.Fatalf(`Expected %s interval "%s", got "%s", %v`, column, expectedExpr, expr, err)
+		}
+	}
+}
 
-	scalar, sign = tsm.guessTimeScalar("tsTab", "millis")
-	if scalar != 1 {
-		t.Fatalf(`expected scalar, sign of 1, true, but got %d, %t`, scalar, sign)
+func Test_GetTimeSeriesDurationIntervalized(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "dt"}
+	var ts map[string][]DataPoint
+	fromTo := QueryRange{From: "2020-03-01", To: "2020-05-01"}
+	err := tsm.GetTimeSeries(context.Background(), "count(x) i(2d)", &fromTo, nil, &ts)
+	if err != nil {
+		t.Fatalf(`Unexpected error querying timeseries "%+v"`, err)
 	}
+	day := int64(86400000)
+	expected := []DataPoint{
+		{Time: 18352 * day, Value: 1},
+		{Time: 18354 * day, Value: 2},
+		{Time: 18356 * day, Value: 1},
+	}
+	if !reflect.DeepEqual(expected, ts["count(x)"]) {
+		t.Fatalf(`Expected intervalized timeseries "%+v", got "%+v"`, expected, ts)
+	}
+}
+
+func Test_buildQueryFiltered(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
 
-	scalar, sign = tsm.guessTimeScalar("tsTab", "nanos")
-	if scalar != 1000000 || sign {
-		t.Fatalf(`expected scalar, sign of 1000000, false, but got %d, %t`, scalar, sign)
+	opts := TimeSeriesQueryOpts{Filters: []QueryFilter{
+		{Key: "tag", Operator: "=", Value: "a"},
+		{Key: "X", Operator: "!~", Value: "^1"},
+	}}
+	query, args, _, err := buildTargetQuery(&tsm, "x", &opts)
+	if err != nil {
+		t.Fatalf(`Unexpected error building filtered query "%+v"`, err)
+	}
+	expectedQuery := `SELECT "ts", "x" FROM "tsTab" WHERE "ts" >= ? AND "ts" < ? AND "tag" = ? AND "x" NOT REGEXP ? ORDER BY "ts"`
+	if query != expectedQuery {
+		t.Fatalf(`Expected query "%s", but got "%s"`, expectedQuery, query)
+	}
+	expectedArgs := []interface{}{"a", "^1"}
+	if !reflect.DeepEqual(expectedArgs, args) {
+		t.Fatalf(`Expected query args "%+v", but got "%+v"`, expectedArgs, args)
 	}
 }
 
-func Test_target2tokens(t *testing.T) {
+func Test_buildQueryFailsOnBadFilters(t *testing.T) {
 	db := createDbWithTable(t)
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
-	value, tags := tsm.target2tokens("x")
-	if value != "x" || len(tags) != 0 {
-		t.Fatalf(
-			`Expected target2tokens to be "x, []", but got "%s, %v"`,
-			value, tags)
+
+	opts := TimeSeriesQueryOpts{Filters: []QueryFilter{{Key: "nope", Operator: "=", Value: "a"}}}
+	if _, _, _, err := buildTargetQuery(&tsm, "x", &opts); err == nil {
+		t.Fatalf("Expected failure filtering on unknown column")
 	}
 
-	value, tags = tsm.target2tokens("x tag")
-	if value != "x" || len(tags) != 1 || tags[0] != "tag" {
-		t.Fatalf(
-			`Expected target2tokens to be "x, [tag]", but got "%s, %v"`,
-			value, tags)
+	opts = TimeSeriesQueryOpts{Filters: []QueryFilter{{Key: "tag", Operator: "; DROP", Value: "a"}}}
+	if _, _, _, err := buildTargetQuery(&tsm, "x", &opts); err == nil {
+		t.Fatalf("Expected failure filtering with unknown operator")
 	}
 }
 
-func Test_target2tokensOnOverspecified(t *testing.T) {
+func Test_GetTimeSeriesFiltered(t *testing.T) {
 	db := createDbWithTable(t)
 	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
-	value, tags := tsm.target2tokens("x tag ...")
-	if value != "x" || len(tags) != 2 {
-		t.Fatalf(
-			`Expected target2tokens "x, tag, ...", but got "%s, %v"`,
-			value, tags)
+	var ts map[string][]DataPoint
+	fromTo := QueryRange{From: "0", To: "10"}
+	opts := TimeSeriesQueryOpts{Filters: []QueryFilter{
+		{Key: "tag", Operator: "=~", Value: "^[ab]$"},
+		{Key: "x", Operator: ">", Value: "100"},
+		{Key: "x", Operator: "<", Value: "400"},
+		{Key: "tag", Operator: "!=", Value: "b"},
+	}}
+	err := tsm.GetTimeSeries(context.Background(), "x tag", &fromTo, &opts, &ts)
+	if err != nil {
+		t.Fatalf(`Unexpected error querying filtered timeseries "%+v"`, err)
+	}
+	if ts == nil || len(ts) != 1 || len(ts["a"]) != 1 ||
+		ts["a"][0] != (DataPoint{Time: 3000, Value: 300.}) {
+		t.Fatalf(`Unexpected filtered timeseries response "%+v"`, ts)
 	}
 }
 
-func createDbWithTable(t *testing.T) *sql.DB {
+func Test_guessTimeUnit(t *testing.T) {
 	db, err := sql.Open("sqlite3", ":memory:")
 	if err != nil {
 		t.Fatal("Cannot create in-memory sqlite DB")
 	}
 
+	queries := []string{
+		"CREATE TABLE tsTab (seconds INT, millis int, nanos INT, dt DATETIME)",
+		"INSERT INTO tsTab (seconds, millis, nanos, dt) VALUES (1585742400, 1585742400000, 1585742400000000000, '2020-04-01 12:00')",
+		"INSERT INTO tsTab (seconds, millis, nanos, dt) VALUES (1585828800, 1585828800000, 1585828800000000000, '2020-04-02 12:00')",
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "dt"}
+	expected := map[string]time.Duration{"seconds": time.Second, "millis": time.Millisecond, "nanos": time.Nanosecond}
+	for column, unit := range expected {
+		if guess, settled := tsm.guessTimeUnit("tsTab", column); guess != unit || !settled {
+			t.Fatalf(`expected %s to hold %v, but guessed %v, %t`, column, unit, guess, settled)
+		}
+	}
+
+	db.Exec("CREATE TABLE empty (ts INT)")
+	if _, settled := tsm.guessTimeUnit("empty", "ts"); settled {
+		t.Fatalf("expected guess without values not to settle the unit")
+	}
+}
+
+func buildTargetQuery(tsm *sqliteTimeSeriesManager, target string, opts *TimeSeriesQueryOpts) (string, []interface{}, *targetQuery, error) {
+	tq, err := tsm.parseTarget(target, opts)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	query, args, err := tsm.buildQuery(tq, opts)
+	return query, args, tq, err
+}
+
+func createDbWithTable(t *testing.T) *sql.DB {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+
 	queries := []string{
 		"CREATE TABLE tsTab (x INT, tag TEXT, ts INT, dt DATETIME, f REAL)",
 		"CREATE INDEX idx_tsTab_ts ON tsTab(ts)",
@@ -437,3 +660,91 @@ func tempFileName(t *testing.T) string {
 	f.Close()
 	return f.Name()
 }
+
+func Test_NewWithOptionsReadOnly(t *testing.T) {
+	dbFileName := tempFileName(t)
+	defer os.Remove(dbFileName)
+	db, err := sql.Open("sqlite3", dbFileName)
+	if err != nil {
+		t.Fatalf(`Cannot create file-backed db at %s: "%+v"`, dbFileName, err)
+	}
+	db.Exec("CREATE TABLE tsTab (x INT, tag TEXT, t INT)")
+	db.Close()
+
+	tsm, err := New(dbFileName, "tsTab", "t")
+	if err != nil {
+		t.Fatalf(`Cannot open read-only db "%+v"`, err)
+	}
+	if _, err := tsm.(*sqliteTimeSeriesManager).db.Exec("INSERT INTO tsTab (x, t) VALUES (1, 1)"); err == nil {
+		t.Fatalf("Expected failure writing to read-only db")
+	}
+
+	tsm, err = NewWithOptions(dbFileName, "tsTab", "t", Options{Writable: true})
+	if err != nil {
+		t.Fatalf(`Cannot open writable db "%+v"`, err)
+	}
+	if _, err := tsm.(*sqliteTimeSeriesManager).db.Exec("INSERT INTO tsTab (x, t) VALUES (1, 1)"); err != nil {
+		t.Fatalf(`Unexpected failure writing to writable db "%+v"`, err)
+	}
+}
+
+func Test_GetTimeSeriesMaxRows(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts", opts: Options{MaxRows: 3}}
+	fromTo := QueryRange{From: "0", To: "10"}
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "x", &fromTo, nil, &ts); err == nil ||
+		!strings.Contains(err.Error(), "more than 3 rows") {
+		t.Fatalf(`Expected max rows failure, but got "%+v"`, err)
+	}
+	if err := tsm.GetTimeSeries(context.Background(), "x i(2s)", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error reading intervalized rows "%+v"`, err)
+	}
+	var table Table
+	if err := tsm.GetTable(context.Background(), "x", &fromTo, nil, &table); err == nil {
+		t.Fatalf("Expected max rows failure for table")
+	}
+}
+
+func Test_GetTimeSeriesCancelled(t *testing.T) {
+	db := createDbWithTable(t)
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "ts"}
+	fromTo := QueryRange{From: "0", To: "10"}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	// counts forever unless interrupted
+	target := "sql: WITH RECURSIVE c(n) AS (SELECT 1 UNION ALL SELECT n+1 FROM c) SELECT max(n), 1 FROM c"
+	start := time.Now()
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(ctx, target, &fromTo, nil, &ts); err == nil {
+		t.Fatalf("Expected cancelled query to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected cancelled query to be interrupted, but ran %v", elapsed)
+	}
+}
+
+func Test_GetTimeSeriesTimezone(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	db.Exec("CREATE TABLE tsTab (x INT, lt TEXT)")
+	db.Exec("INSERT INTO tsTab (x, lt) VALUES (1, '2020-04-01 07:00:00'), (2, '2020-04-01 09:00:00')")
+	loc, err := time.LoadLocation("America/Chicago")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	tsm := sqliteTimeSeriesManager{db: db, table: "tsTab", timeColumn: "lt", opts: Options{Location: loc}}
+
+	// 12:00 to 14:00 UTC covers 07:00 to 09:00 CDT
+	fromTo := QueryRange{From: "2020-04-01T12:00:00Z", To: "2020-04-01T14:00:00Z"}
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "x", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error reading local times "%+v"`, err)
+	}
+	expected := time.Date(2020, 4, 1, 12, 0, 0, 0, time.UTC).UnixNano() / 1000000
+	if len(ts["x"]) != 1 || ts["x"][0].Time != expected {
+		t.Fatalf(`Expected one point at %d, but got "%+v"`, expected, ts)
+	}
+}
@@ -0,0 +1,123 @@
+package sqlite3
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// seriesTransform names a transform applied to each series of a target,
+// with the number of points averaged by movingavg.
+type seriesTransform struct {
+	name   string
+	window int
+}
+
+// Transforms of target options, mapped to whether they take a number of
+// points.
+var seriesTransforms = map[string]bool{
+	"rate":      false,
+	"delta":     false,
+	"cumsum":    false,
+	"movingavg": true,
+}
+
+// Build the transform of a target option from its name and argument, empty
+// for transforms without.
+func newSeriesTransform(name string, arg string) (seriesTransform, error) {
+	takesWindow, ok := seriesTransforms[name]
+	if !ok {
+		return seriesTransform{}, errors.Errorf(`unknown transform "%s"`, name)
+	}
+	if !takesWindow {
+		if arg != "" {
+			return seriesTransform{}, errors.Errorf("%s() takes no argument", name)
+		}
+		return seriesTransform{name: name}, nil
+	}
+	window, err := strconv.Atoi(arg)
+	if err != nil || window <= 0 {
+		return seriesTransform{}, errors.Errorf("%s() expects a positive number of points", name)
+	}
+	return seriesTransform{name: name, window: window}, nil
+}
+
+// Apply the transform to a time-ordered series.
+func (st seriesTransform) apply(pts []DataPoint) []DataPoint {
+	switch st.name {
+	case "rate":
+		return counterChanges(pts, true)
+	case "delta":
+		return counterChanges(pts, false)
+	case "cumsum":
+		return cumulativeSum(pts)
+	default:
+		return movingAverage(pts, st.window)
+	}
+}
+
+// Find the increase of a counter since its previous value, per second of
+// the time between the values for rates.  A decrease is taken as a counter
+// reset to zero, so the increase is the value itself.  The first point has
+// no previous value and is dropped, as are points at the same time as the
+// previous, while null points stay null.
+func counterChanges(pts []DataPoint, perSecond bool) []DataPoint {
+	var result []DataPoint
+	var prev *DataPoint
+	for i := range pts {
+		pt := pts[i]
+		if pt.Null {
+			if prev != nil {
+				result = append(result, pt)
+			}
+			continue
+		}
+		if prev != nil && pt.Time > prev.Time {
+			increase := pt.Value - prev.Value
+			if increase < 0 {
+				increase = pt.Value
+			}
+			if perSecond {
+				increase /= float64(pt.Time-prev.Time) / 1000
+			}
+			result = append(result, DataPoint{Time: pt.Time, Value: increase})
+		}
+		prev = &pts[i]
+	}
+	return result
+}
+
+// Sum the values of the series up to each point, skipping nulls.
+func cumulativeSum(pts []DataPoint) []DataPoint {
+	result := make([]DataPoint, len(pts))
+	sum := 0.0
+	for i, pt := range pts {
+		if !pt.Null {
+			sum += pt.Value
+			pt.Value = sum
+		}
+		result[i] = pt
+	}
+	return result
+}
+
+// Average each value with the values before it, up to the window of values,
+// skipping nulls.
+func movingAverage(pts []DataPoint, window int) []DataPoint {
+	result := make([]DataPoint, len(pts))
+	var values []float64
+	sum := 0.0
+	for i, pt := range pts {
+		if !pt.Null {
+			values = append(values, pt.Value)
+			sum += pt.Value
+			if len(values) > window {
+				sum -= values[0]
+				values = values[1:]
+			}
+			pt.Value = sum / float64(len(values))
+		}
+		result[i] = pt
+	}
+	return result
+}
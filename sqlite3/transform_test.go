@@ -0,0 +1,69 @@
+package sqlite3
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func Test_seriesTransforms(t *testing.T) {
+	// a counter reset to zero after 30
+	pts := []DataPoint{
+		{Time: 0, Value: 10},
+		{Time: 2000, Value: 20},
+		{Time: 3000, Null: true},
+		{Time: 4000, Value: 30},
+		{Time: 8000, Value: 4},
+	}
+	cases := []struct {
+		transform seriesTransform
+		expected  []DataPoint
+	}{
+		{seriesTransform{name: "delta"}, []DataPoint{
+			{Time: 2000, Value: 10}, {Time: 3000, Null: true}, {Time: 4000, Value: 10}, {Time: 8000, Value: 4}}},
+		{seriesTransform{name: "rate"}, []DataPoint{
+			{Time: 2000, Value: 5}, {Time: 3000, Null: true}, {Time: 4000, Value: 5}, {Time: 8000, Value: 1}}},
+		{seriesTransform{name: "cumsum"}, []DataPoint{
+			{Time: 0, Value: 10}, {Time: 2000, Value: 30}, {Time: 3000, Null: true}, {Time: 4000, Value: 60},
+			{Time: 8000, Value: 64}}},
+		{seriesTransform{name: "movingavg", window: 2}, []DataPoint{
+			{Time: 0, Value: 10}, {Time: 2000, Value: 15}, {Time: 3000, Null: true}, {Time: 4000, Value: 25},
+			{Time: 8000, Value: 17}}},
+	}
+	for _, c := range cases {
+		if result := c.transform.apply(pts); !reflect.DeepEqual(c.expected, result) {
+			t.Fatalf("expected %s to give %+v, got %+v", c.transform.name, c.expected, result)
+		}
+	}
+}
+
+func Test_GetTimeSeriesTransformed(t *testing.T) {
+	db, err := sql.Open(driverName, ":memory:")
+	if err != nil {
+		t.Fatal("Cannot create in-memory sqlite DB")
+	}
+	queries := []string{
+		"CREATE TABLE counters (ts INT, host TEXT, requests INT)",
+		`INSERT INTO counters VALUES (0, 'a', 100), (0, 'b', 5), (10, 'a', 150), (10, 'b', 25),
+			(30, 'a', 10), (30, 'b', 45)`,
+	}
+	for _, q := range queries {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf(`cannot issue query "%s" for test: %+v`, q, err)
+		}
+	}
+	tsm := sqliteTimeSeriesManager{db: db, table: "counters", timeColumn: "ts"}
+	fromTo := QueryRange{From: "0", To: "60"}
+	var ts map[string][]DataPoint
+	if err := tsm.GetTimeSeries(context.Background(), "requests host rate() cumsum()", &fromTo, nil, &ts); err != nil {
+		t.Fatalf(`Unexpected error transforming time series "%+v"`, err)
+	}
+	expected := map[string][]DataPoint{
+		"a": {{Time: 10000, Value: 5}, {Time: 30000, Value: 5.5}},
+		"b": {{Time: 10000, Value: 2}, {Time: 30000, Value: 3}},
+	}
+	if !reflect.DeepEqual(expected, ts) {
+		t.Fatalf("Expected transformed series %+v, got %+v", expected, ts)
+	}
+}
@@ -0,0 +1,33 @@
+package timecodex
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var dayDuration = regexp.MustCompile(`^([0-9]+)([dw])$`)
+
+// ParseDuration interprets a duration as used by Go and Grafana, e.g.
+// "10s", "5m", "1h30m", extended with days and weeks, e.g. "1d" or "2w".
+func ParseDuration(durationStr string) (time.Duration, error) {
+	if match := dayDuration.FindStringSubmatch(durationStr); match != nil {
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return 0, errors.Errorf(`cannot parse duration "%s"`, durationStr)
+		}
+		unit := 24 * time.Hour
+		if match[2] == "w" {
+			unit *= 7
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(durationStr)
+	if err != nil {
+		return 0, errors.Errorf(`cannot parse duration "%s"`, durationStr)
+	}
+	return d, nil
+}
@@ -9,20 +9,52 @@ import (
 )
 
 var isYyyymmdd = regexp.MustCompile(`^[0-9]{2,4}[/\- ][0-9]{1,2}[/\- ][0-9]{1,2}$`)
+var splitYyyymmdd = regexp.MustCompile(`[/\- ]`)
+
+// Layouts SQLite uses for date and time values lacking a time zone, e.g.
+// the output of datetime().
+var sqliteLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+}
 
 // Interpret a string as a time.
 // Possibilities are:
 //  - RFC3339
+//  - YYYY-MM-DD HH:MM[:SS] (space or T separator) as UTC
 //  - YYYYY-MM-DD (slash, hyphen, or space separators)
 //  - Integer treated as seconds or milliseconds from January 1, 1970 UTC
+//  - Grafana relative times, e.g. "now-7d", rounded down, see ParseRelativeTime
 func StringToTime(dateTimeStr string) (time.Time, error) {
+	return StringToTimeIn(dateTimeStr, time.UTC)
+}
+
+// StringToTimeIn interprets a string as a time, as StringToTime, but
+// interpreting times lacking a time zone in the location.
+func StringToTimeIn(dateTimeStr string, loc *time.Location) (time.Time, error) {
+	if IsRelativeTime(dateTimeStr) {
+		return ParseRelativeTime(dateTimeStr, Now().In(loc), false)
+	}
+
 	result, err := time.Parse(time.RFC3339, dateTimeStr)
 	if err == nil {
 		return result, err
 	}
 
+	for _, layout := range sqliteLayouts {
+		if result, err := time.ParseInLocation(layout, dateTimeStr, loc); err == nil {
+			return result, nil
+		}
+	}
+
 	if isYyyymmdd.MatchString(dateTimeStr) {
-		return time.Parse(time.RFC3339, dateTimeStr+"T0:00:00Z")
+		parts := splitYyyymmdd.Split(dateTimeStr, 3)
+		year, _ := strconv.Atoi(parts[0])
+		month, _ := strconv.Atoi(parts[1])
+		day, _ := strconv.Atoi(parts[2])
+		return time.Date(year, time.Month(month), day, 0, 0, 0, 0, loc), nil
 	}
 
 	epochs, err := strconv.Atoi(dateTimeStr)
@@ -0,0 +1,109 @@
+package timecodex
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Now reads the clock for relative times, and may be replaced in tests.
+var Now = time.Now
+
+var relativeOp = regexp.MustCompile(`^(?:([+-])([0-9]*)|/)([smhdwMy])`)
+
+// IsRelativeTime checks whether a string is a Grafana relative time, as
+// parsed by ParseRelativeTime.
+func IsRelativeTime(timeStr string) bool {
+	return strings.HasPrefix(strings.TrimSpace(timeStr), "now")
+}
+
+// ParseRelativeTime interprets a Grafana relative time, "now" followed by
+// offsets, e.g. "now-7d" or "now+1h", and rounding to the start of a unit,
+// e.g. "now/d", in the location of now.  Units are s, m, h, d, w, M, and y,
+// for seconds, minutes, hours, days, weeks starting Monday, months, and
+// years, and offsets without a number are by one unit.  With roundUp,
+// rounding goes to the last millisecond of the unit instead, as Grafana
+// rounds the end of a time range, so "now/d" ends today.
+func ParseRelativeTime(timeStr string, now time.Time, roundUp bool) (time.Time, error) {
+	expr := strings.TrimSpace(timeStr)
+	if !strings.HasPrefix(expr, "now") {
+		return now, errors.Errorf(`cannot parse relative time "%s", expected "now"`, timeStr)
+	}
+	t := now
+	for rest := expr[len("now"):]; rest != ""; {
+		match := relativeOp.FindStringSubmatch(rest)
+		if match == nil {
+			return now, errors.Errorf(`cannot parse relative time "%s" at "%s"`, timeStr, rest)
+		}
+		rest = rest[len(match[0]):]
+		unit := match[3][0]
+		if match[1] == "" {
+			t = roundTime(t, unit, roundUp)
+			continue
+		}
+		n := 1
+		if match[2] != "" {
+			var err error
+			if n, err = strconv.Atoi(match[2]); err != nil {
+				return now, errors.Errorf(`cannot parse relative time "%s": %v`, timeStr, err)
+			}
+		}
+		if match[1] == "-" {
+			n = -n
+		}
+		t = addUnits(t, n, unit)
+	}
+	return t, nil
+}
+
+// Add a number of the unit to the time, by calendar for days and longer.
+func addUnits(t time.Time, n int, unit byte) time.Time {
+	switch unit {
+	case 's':
+		return t.Add(time.Duration(n) * time.Second)
+	case 'm':
+		return t.Add(time.Duration(n) * time.Minute)
+	case 'h':
+		return t.Add(time.Duration(n) * time.Hour)
+	case 'd':
+		return t.AddDate(0, 0, n)
+	case 'w':
+		return t.AddDate(0, 0, 7*n)
+	case 'M':
+		return t.AddDate(0, n, 0)
+	default:
+		return t.AddDate(n, 0, 0)
+	}
+}
+
+// Round the time down to the start of the unit, or up to its last
+// millisecond.
+func roundTime(t time.Time, unit byte, roundUp bool) time.Time {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	var start time.Time
+	switch unit {
+	case 's':
+		start = time.Date(year, month, day, hour, min, sec, 0, t.Location())
+	case 'm':
+		start = time.Date(year, month, day, hour, min, 0, 0, t.Location())
+	case 'h':
+		start = time.Date(year, month, day, hour, 0, 0, 0, t.Location())
+	case 'd':
+		start = time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+	case 'w':
+		sinceMonday := (int(t.Weekday()) + 6) % 7
+		start = time.Date(year, month, day-sinceMonday, 0, 0, 0, 0, t.Location())
+	case 'M':
+		start = time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	default:
+		start = time.Date(year, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	if !roundUp {
+		return start
+	}
+	return addUnits(start, 1, unit).Add(-time.Millisecond)
+}
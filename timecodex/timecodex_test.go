@@ -36,3 +36,108 @@ func Test_NumberToTime(t *testing.T) {
 			expectedNanos, nanos, ts)
 	}
 }
+
+func Test_ParseDuration(t *testing.T) {
+	cases := map[string]time.Duration{
+		"10s":   10 * time.Second,
+		"5m":    5 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"250ms": 250 * time.Millisecond,
+		"1d":    24 * time.Hour,
+		"2w":    14 * 24 * time.Hour,
+	}
+	for str, expected := range cases {
+		d, err := ParseDuration(str)
+		if err != nil || d != expected {
+			t.Fatalf(`expected duration "%s" to be %v, got %v, %v`, str, expected, d, err)
+		}
+	}
+
+	for _, str := range []string{"", "d", "1x", "1.5d"} {
+		if _, err := ParseDuration(str); err == nil {
+			t.Fatalf(`expected error parsing duration "%s"`, str)
+		}
+	}
+}
+
+func Test_StringToTime(t *testing.T) {
+	expected := time.Date(2020, 4, 1, 12, 30, 0, 0, time.UTC)
+	for _, str := range []string{"2020-04-01T12:30:00Z", "2020-04-01 12:30:00", "2020-04-01T12:30", "1585744200"} {
+		ts, err := StringToTime(str)
+		if err != nil || !ts.Equal(expected) {
+			t.Fatalf(`expected "%s" to parse as %v, got %v, %v`, str, expected, ts, err)
+		}
+	}
+
+	ts, err := StringToTime("2020-04-01")
+	if err != nil || !ts.Equal(time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf(`expected "2020-04-01" to parse as midnight, got %v, %v`, ts, err)
+	}
+}
+
+func Test_StringToTimeIn(t *testing.T) {
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	expected := time.Date(2020, 4, 1, 17, 30, 0, 0, time.UTC)
+	for _, str := range []string{"2020-04-01T17:30:00Z", "2020-04-01 12:30:00", "2020-04-01T12:30"} {
+		ts, err := StringToTimeIn(str, loc)
+		if err != nil || !ts.Equal(expected) {
+			t.Fatalf(`expected "%s" to parse as %v, got %v, %v`, str, expected, ts, err)
+		}
+	}
+
+	ts, err := StringToTimeIn("2020/04/01", loc)
+	if err != nil || !ts.Equal(time.Date(2020, 4, 1, 5, 0, 0, 0, time.UTC)) {
+		t.Fatalf(`expected "2020/04/01" to parse as local midnight, got %v, %v`, ts, err)
+	}
+}
+
+func Test_ParseRelativeTime(t *testing.T) {
+	now := time.Date(2020, 4, 1, 12, 30, 15, 500000000, time.UTC) // a Wednesday
+	cases := []struct {
+		expr     string
+		roundUp  bool
+		expected time.Time
+	}{
+		{"now", false, now},
+		{"now-7d", false, time.Date(2020, 3, 25, 12, 30, 15, 500000000, time.UTC)},
+		{"now+90s", false, time.Date(2020, 4, 1, 12, 31, 45, 500000000, time.UTC)},
+		{"now-h", false, time.Date(2020, 4, 1, 11, 30, 15, 500000000, time.UTC)},
+		{"now-1M", false, time.Date(2020, 3, 1, 12, 30, 15, 500000000, time.UTC)},
+		{"now-2y", false, time.Date(2018, 4, 1, 12, 30, 15, 500000000, time.UTC)},
+		{"now/d", false, time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"now/d", true, time.Date(2020, 4, 1, 23, 59, 59, 999000000, time.UTC)},
+		{"now/w", false, time.Date(2020, 3, 30, 0, 0, 0, 0, time.UTC)},
+		{"now-1M/M", false, time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"now-1M/M", true, time.Date(2020, 3, 31, 23, 59, 59, 999000000, time.UTC)},
+		{"now/y", false, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"now-1d/d+6h", false, time.Date(2020, 3, 31, 6, 0, 0, 0, time.UTC)},
+		{"now/m", false, time.Date(2020, 4, 1, 12, 30, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		ts, err := ParseRelativeTime(c.expr, now, c.roundUp)
+		if err != nil || !ts.Equal(c.expected) {
+			t.Fatalf(`expected "%s" to parse as %v, got %v, %v`, c.expr, c.expected, ts, err)
+		}
+	}
+
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	ts, err := ParseRelativeTime("now/d", now.In(loc), false)
+	if err != nil || !ts.Equal(time.Date(2020, 4, 1, 5, 0, 0, 0, time.UTC)) {
+		t.Fatalf(`expected "now/d" to round to local midnight, got %v, %v`, ts, err)
+	}
+
+	for _, expr := range []string{"yesterday", "now-", "now-7x", "now/", "now-1.5d"} {
+		if _, err := ParseRelativeTime(expr, now, false); err == nil {
+			t.Fatalf(`expected "%s" to fail to parse`, expr)
+		}
+	}
+}
+
+func Test_StringToTimeRelative(t *testing.T) {
+	defer func() { Now = time.Now }()
+	Now = func() time.Time { return time.Date(2020, 4, 1, 12, 30, 0, 0, time.UTC) }
+	ts, err := StringToTime("now-1h")
+	if err != nil || !ts.Equal(time.Date(2020, 4, 1, 11, 30, 0, 0, time.UTC)) {
+		t.Fatalf(`expected "now-1h" to parse an hour before now, got %v, %v`, ts, err)
+	}
+}